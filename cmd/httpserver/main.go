@@ -1,51 +1,182 @@
 package main
 
 import (
-	"crypto/sha256"
-	"fmt"
-	"io"
+	"context"
+	"errors"
+	"flag"
 	"log"
-	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/proxy"
+	"github.com/MadhurSahu/tcp-to-http/internal/proxyproto"
 	"github.com/MadhurSahu/tcp-to-http/internal/request"
 	"github.com/MadhurSahu/tcp-to-http/internal/response"
 	"github.com/MadhurSahu/tcp-to-http/internal/server"
+	"github.com/MadhurSahu/tcp-to-http/internal/sse"
+	"github.com/MadhurSahu/tcp-to-http/internal/static"
+	"github.com/MadhurSahu/tcp-to-http/internal/websocket"
 )
 
-const port = 42069
+const (
+	port        = 42069
+	maxBodySize = 10 << 20
+	// shutdownTimeout bounds how long in-flight requests get to finish on shutdown or restart
+	shutdownTimeout = 30 * time.Second
+)
+
+var assets = static.FileServer(static.Dir("assets"), static.WithStripPrefix("/assets"), static.WithDirectoryListing())
+
+var httpbin server.Handler
+
+// forward is only set with -forward-proxy, and refuses destinations on this machine or its networks
+var forward server.Handler
+
+var echo = websocket.Handler(func(conn *websocket.Conn, req *request.Request) {
+	for {
+		opcode, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		err = conn.WriteMessage(opcode, data)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}, websocket.WithCompression())
 
 func main() {
-	srv, err := server.Serve(port, handler)
+	certFile := flag.String("cert", "", "TLS certificate file, serves HTTPS together with -key")
+	keyFile := flag.String("key", "", "TLS private key file")
+	dev := flag.Bool("dev", false, "serve HTTPS with a generated certificate for localhost")
+	socket := flag.String("unix", "", "listen on this Unix socket instead of the TCP port")
+	proxyFrom := flag.String("proxy-protocol", "", "comma separated CIDRs of load balancers sending PROXY protocol headers")
+	forwardProxy := flag.Bool("forward-proxy", false, "act as a forward proxy for CONNECT and absolute-form requests")
+	flag.Parse()
+
+	if *forwardProxy {
+		forward = proxy.Forward()
+	}
+
+	//docker run -p 8080:80 kennethreitz/httpbin
+	var err error
+	httpbin, err = proxy.Reverse([]string{"http://localhost:8080"}, proxy.WithStripPrefix("/httpbin"))
+	if err != nil {
+		log.Fatalf("Error configuring proxy: %v", err)
+	}
+
+	opts := []server.Option{server.WithMaxBodySize(maxBodySize), server.WithDecompression(maxBodySize)}
+	if *certFile != "" || *keyFile != "" {
+		opts = append(opts, server.WithTLS(*certFile, *keyFile))
+	} else if *dev {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			log.Fatalf("Error locating cache directory: %v", err)
+		}
+
+		dc, err := server.GenerateDevCertificate(filepath.Join(cacheDir, "tcp-to-http"))
+		if err != nil {
+			log.Fatalf("Error generating development certificate: %v", err)
+		}
+		log.Println("Trust the development CA to avoid browser warnings:", dc.CAFile)
+		opts = append(opts, server.WithTLS(dc.CertFile, dc.KeyFile))
+	}
+
+	if *proxyFrom != "" {
+		var trusted []netip.Prefix
+		for _, cidr := range strings.Split(*proxyFrom, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+			if err != nil {
+				log.Fatalf("Error parsing -proxy-protocol: %v", err)
+			}
+			trusted = append(trusted, prefix)
+		}
+		opts = append(opts, server.WithProxyProtocol(proxyproto.WithTrustedSources(trusted...)))
+	}
+
+	listeners, err := server.ActivationListeners()
+	if err != nil {
+		log.Fatalf("Error taking inherited listeners: %v", err)
+	}
+
+	var srv *server.Server
+	if len(listeners) > 0 {
+		for _, extra := range listeners[1:] {
+			log.Println("Ignoring extra inherited listener on", extra.Addr())
+			extra.Close()
+		}
+		srv, err = server.ServeListener(listeners[0], server.Compress(server.AutoFrame(handler)), opts...)
+	} else {
+		network, address := "tcp", ":"+strconv.Itoa(port)
+		if *socket != "" {
+			network, address = "unix", *socket
+			opts = append(opts, server.WithSocketMode(0o660))
+		}
+		srv, err = server.Listen(network, address, server.Compress(server.AutoFrame(handler)), opts...)
+	}
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer srv.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", srv.Addr())
+	signalReady()
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+
+		err := restart(srv)
+		if err != nil {
+			log.Printf("Error restarting, still serving: %v", err)
+			continue
+		}
+		log.Println("Handed the listener over to the new process")
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Error waiting for connections to finish: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
 
 func handler(w *response.Writer, req *request.Request) *server.HandlerError {
 	path := req.RequestLine.RequestTarget
 
-	internalError := &server.HandlerError{
-		StatusCode: response.StatusCodeInternalServerError,
+	internalError := func(err error) *server.HandlerError {
+		return &server.HandlerError{
+			StatusCode: response.StatusCodeInternalServerError,
+			Err:        err,
+		}
 	}
 
 	badRequestError := &server.HandlerError{
 		StatusCode: response.StatusCodeBadRequest,
 	}
 
+	if req.RequestLine.Method == "CONNECT" || !strings.HasPrefix(path, "/") {
+		if forward == nil {
+			return &server.HandlerError{StatusCode: response.StatusCodeBadRequest, Message: "not a proxy"}
+		}
+		return forward(w, req)
+	}
+
 	if path == "/myproblem" {
-		return internalError
+		return internalError(nil)
 	}
 
 	if path == "/yourproblem" {
@@ -53,108 +184,91 @@ func handler(w *response.Writer, req *request.Request) *server.HandlerError {
 	}
 
 	if path == "/video" {
-		err := w.WriteStatusLine(response.StatusCodeOK)
-		if err != nil {
-			log.Println(err)
-			return internalError
-		}
-
-		file, err := os.ReadFile("assets/vim.mp4")
-		if err != nil {
-			log.Println(err)
-			return internalError
-		}
-
-		h := headers.GetDefaultHeaders(len(file))
-		h.Overwrite("Content-Type", "video/mp4")
-		err = w.WriteHeaders(h)
+		file, err := os.Open("assets/vim.mp4")
 		if err != nil {
-			log.Println(err)
-			return internalError
+			return internalError(err)
 		}
+		defer file.Close()
 
-		_, err = w.WriteBody(file)
+		err = w.ServeFile(req, file, "video/mp4")
 		if err != nil {
-			log.Println(err)
-			return internalError
+			return internalError(err)
 		}
 		return nil
 	}
 
-	if strings.HasPrefix(path, "/httpbin/") {
-		endpoint := strings.TrimPrefix(path, "/httpbin/")
-
-		if endpoint == "" {
-			return badRequestError
+	if path == "/api/echo" {
+		var payload map[string]any
+		err := request.DecodeJSON(req, &payload)
+		if errors.Is(err, request.ErrUnsupportedMediaType) {
+			return &server.HandlerError{StatusCode: response.StatusCodeUnsupportedMediaType}
+		}
+		if errors.Is(err, request.ErrJSONTooLarge) {
+			return &server.HandlerError{StatusCode: response.StatusCodeRequestEntityTooLarge}
 		}
-
-		err := w.WriteStatusLine(response.StatusCodeOK)
 		if err != nil {
-			return internalError
+			return &server.HandlerError{
+				StatusCode: response.StatusCodeBadRequest,
+				Message:    err.Error(),
+			}
 		}
 
-		h := headers.GetDefaultHeaders(0)
-		h.Delete("Content-Length")
-		h.Overwrite("Transfer-Encoding", "chunked")
-		h.Overwrite("Trailer", "X-Content-SHA256, X-Content-Length")
-
-		err = w.WriteHeaders(h)
+		err = response.WriteJSON(w, response.StatusCodeOK, payload)
 		if err != nil {
 			log.Println(err)
-			return internalError
 		}
+		return nil
+	}
 
-		//docker run -p 8080:80 kennethreitz/httpbin
-		res, err := http.Get("http://localhost:8080/" + endpoint)
+	if path == "/events" {
+		stream, err := sse.NewStream(w, req)
 		if err != nil {
-			log.Println(err)
-			return internalError
+			return internalError(err)
 		}
-		defer res.Body.Close()
 
-		full := make([]byte, 0)
-		body := make([]byte, 1024)
+		next := 0
+		if id, err := strconv.Atoi(stream.LastEventID()); err == nil {
+			next = id + 1
+		}
 
-		for {
-			n, err := res.Body.Read(body)
-			if n > 0 {
-				_, err = w.WriteChunkedBody(body[:n])
-				if err != nil {
-					fmt.Println("Error writing chunked body:", err)
-					break
+		ctx := req.Context()
+		events := make(chan sse.Event)
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-ticker.C:
+					select {
+					case events <- sse.Event{ID: strconv.Itoa(next), Data: t.Format(time.RFC3339)}:
+						next++
+					case <-ctx.Done():
+						return
+					}
 				}
-				full = append(full, body[:n]...)
-			}
-
-			if err == io.EOF {
-				break
 			}
+		}()
 
-			if err != nil {
-				log.Println(err)
-				return internalError
-			}
-		}
-
-		_, err = w.WriteChunkedBodyDone()
+		err = stream.Run(ctx, events, sse.DefaultHeartbeat)
 		if err != nil {
 			log.Println(err)
-			return internalError
 		}
+		return nil
+	}
 
-		checksum := fmt.Sprintf("%x", sha256.Sum256(full))
-		contentLength := fmt.Sprintf("%d", len(full))
-		trailerHeaders := headers.NewHeaders()
-		trailerHeaders.Set("X-Content-SHA256", checksum)
-		trailerHeaders.Set("X-Content-Length", contentLength)
+	if path == "/ws" {
+		return echo(w, req)
+	}
 
-		err = w.WriteTrailers(trailerHeaders)
-		if err != nil {
-			log.Println(err)
-			return internalError
-		}
+	if path == "/assets" || strings.HasPrefix(path, "/assets/") {
+		return assets(w, req)
+	}
 
-		return nil
+	if strings.HasPrefix(path, "/httpbin/") {
+		return httpbin(w, req)
 	}
 
 	body := `<html>
@@ -167,21 +281,38 @@ func handler(w *response.Writer, req *request.Request) *server.HandlerError {
   </body>
 </html>`
 
-	err := w.WriteStatusLine(response.StatusCodeOK)
+	if req.Negotiate("text/html") == "" {
+		return &server.HandlerError{StatusCode: response.StatusCodeNotAcceptable}
+	}
+
+	etag := response.ETag([]byte(body))
+	handled, err := w.CheckPreconditions(req, etag, time.Time{})
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if handled {
+		return nil
+	}
+
+	err = w.WriteStatusLine(response.StatusCodeOK)
 	if err != nil {
-		return internalError
+		return internalError(err)
 	}
 
-	h := headers.GetDefaultHeaders(len(body))
-	h.Overwrite("Content-Type", "text/html")
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/html")
+	h.Set("ETag", etag)
 	err = w.WriteHeaders(h)
 	if err != nil {
-		return internalError
+		return internalError(err)
 	}
 
 	_, err = w.WriteBody([]byte(body))
 	if err != nil {
-		return internalError
+		return internalError(err)
 	}
 	return nil
 }
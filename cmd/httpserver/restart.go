@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+)
+
+const (
+	// readyFdEnv names the descriptor a restarted process writes to once it accepts connections
+	readyFdEnv = "HTTPSERVER_READY_FD"
+	// restartTimeout bounds how long the new process gets to start accepting
+	restartTimeout = 30 * time.Second
+)
+
+// restart starts a new copy of this program serving on the same listening socket and waits until it
+// accepts connections. If the new process fails to come up it is killed and this one keeps serving
+func restart(srv *server.Server) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	listenerFile, err := srv.ListenerFile()
+	if err != nil {
+		return err
+	}
+	defer listenerFile.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") && !strings.HasPrefix(kv, readyFdEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+
+	// ExtraFiles start at descriptor 3, where systemd puts activated sockets
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{listenerFile, readyWriter}
+	cmd.Env = append(env, "LISTEN_FDS=1", readyFdEnv+"=4")
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	// The pipe reports EOF early if the new process exits before it is ready
+	err = readyReader.SetReadDeadline(time.Now().Add(restartTimeout))
+	if err == nil {
+		_, err = readyReader.Read(make([]byte, 1))
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("new process not ready after %s", restartTimeout)
+		}
+		return fmt.Errorf("new process exited before it was ready: %w", err)
+	}
+
+	// The new process serves on the socket file now, so it must outlive this one
+	srv.KeepSocketFile()
+	return cmd.Process.Release()
+}
+
+// signalReady tells the process that restarted this one that it can stop serving
+func signalReady() {
+	fdStr, exists := os.LookupEnv(readyFdEnv)
+	if !exists {
+		return
+	}
+	os.Unsetenv(readyFdEnv)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return
+	}
+
+	ready := os.NewFile(uintptr(fd), "ready")
+	_, _ = ready.Write([]byte{1})
+	ready.Close()
+}
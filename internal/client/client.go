@@ -0,0 +1,433 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+)
+
+const (
+	DefaultDialTimeout         = 10 * time.Second
+	DefaultIdleTimeout         = 90 * time.Second
+	DefaultMaxIdleConnsPerHost = 2
+
+	// maxLineSize bounds a single status or header line of a response
+	maxLineSize = 64 << 10
+)
+
+type Option func(*Client)
+
+type Client struct {
+	dialTimeout         time.Duration
+	idleTimeout         time.Duration
+	maxIdleConnsPerHost int
+	tlsConfig           *tls.Config
+	dialControl         func(network, address string, c syscall.RawConn) error
+
+	mu     sync.Mutex
+	idle   map[string][]*conn
+	reaper *time.Timer
+	closed bool
+}
+
+type Response struct {
+	StatusCode int
+	Reason     string
+	Proto      string
+	Headers    headers.Headers
+	Body       io.ReadCloser
+	// Trailers is filled in once Body has been read to the end
+	Trailers headers.Headers
+}
+
+type conn struct {
+	net.Conn
+	key       string
+	host      string
+	reader    *bufio.Reader
+	writer    *bufio.Writer
+	idleSince time.Time
+}
+
+// body hands its connection back to the pool once the response has been read in full, and closes it
+// when the caller gives up early or the connection cannot be reused
+type body struct {
+	reader   io.Reader
+	conn     *conn
+	client   *Client
+	reusable bool
+	stop     func() bool
+	mu       sync.Mutex
+	done     bool
+}
+
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = timeout
+	}
+}
+
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.idleTimeout = timeout
+	}
+}
+
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.maxIdleConnsPerHost = n
+	}
+}
+
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithDialControl runs control on every connection before it is established, with the resolved
+// address, so a caller can refuse destinations no matter how the host was spelled
+func WithDialControl(control func(network, address string, c syscall.RawConn) error) Option {
+	return func(c *Client) {
+		c.dialControl = control
+	}
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		dialTimeout:         DefaultDialTimeout,
+		idleTimeout:         DefaultIdleTimeout,
+		maxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		idle:                make(map[string][]*conn),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close closes every idle connection. Responses still being read keep their connections until done
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.reaper != nil {
+		c.reaper.Stop()
+		c.reaper = nil
+	}
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.Close()
+		}
+		delete(c.idle, key)
+	}
+	return nil
+}
+
+// Do sends req to origin, an http or https URL naming the scheme, host and optional port, and
+// returns once the response headers have arrived. The caller must close the response body
+func (c *Client) Do(ctx context.Context, origin string, req *request.Request) (*Response, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid origin: %s", origin)
+	}
+
+	for attempt := 0; ; attempt++ {
+		pc, reused, err := c.getConn(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.roundTrip(ctx, pc, req)
+		if err == nil {
+			return res, nil
+		}
+
+		// The server may have closed a pooled connection while it sat idle, so try once more on a fresh one
+		if reused && attempt == 0 && idempotent(req.RequestLine.Method) && ctx.Err() == nil {
+			continue
+		}
+		return nil, err
+	}
+}
+
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req := request.NewRequest("GET", u.RequestURI(), nil)
+	req.Headers.Overwrite("Host", u.Host)
+	return c.Do(ctx, u.Scheme+"://"+u.Host, req)
+}
+
+func (c *Client) getConn(ctx context.Context, u *url.URL) (*conn, bool, error) {
+	key := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		pc := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+
+		if time.Since(pc.idleSince) > c.idleTimeout {
+			pc.Close()
+			continue
+		}
+
+		c.mu.Unlock()
+		return pc, true, nil
+	}
+	c.mu.Unlock()
+
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{Timeout: c.dialTimeout, Control: c.dialControl}
+	var netConn net.Conn
+	var err error
+	if u.Scheme == "https" {
+		config := &tls.Config{}
+		if c.tlsConfig != nil {
+			config = c.tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		config.NextProtos = []string{"http/1.1"}
+
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &conn{
+		Conn:   netConn,
+		key:    key,
+		host:   u.Host,
+		reader: bufio.NewReaderSize(netConn, maxLineSize),
+		writer: bufio.NewWriter(netConn),
+	}, false, nil
+}
+
+func (c *Client) release(pc *conn) {
+	err := pc.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil || c.closed || len(c.idle[pc.key]) >= c.maxIdleConnsPerHost {
+		pc.Close()
+		return
+	}
+
+	pc.idleSince = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+	if c.reaper == nil {
+		c.reaper = time.AfterFunc(c.idleTimeout, c.reap)
+	}
+}
+
+// reap closes connections that sat idle past the idle timeout, and runs again for the next one to
+// expire while any remain
+func (c *Client) reap() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reaper = nil
+	if c.closed {
+		return
+	}
+
+	now := time.Now()
+	var next time.Time
+	for key, conns := range c.idle {
+		kept := conns[:0]
+		for _, pc := range conns {
+			expiry := pc.idleSince.Add(c.idleTimeout)
+			if !now.Before(expiry) {
+				pc.Close()
+				continue
+			}
+
+			kept = append(kept, pc)
+			if next.IsZero() || expiry.Before(next) {
+				next = expiry
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(c.idle, key)
+		} else {
+			c.idle[key] = kept
+		}
+	}
+
+	if !next.IsZero() {
+		c.reaper = time.AfterFunc(next.Sub(now), c.reap)
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, pc *conn, req *request.Request) (*Response, error) {
+	// Cancelling ctx interrupts whatever read or write is in flight on the connection
+	stop := context.AfterFunc(ctx, func() {
+		_ = pc.SetDeadline(time.Unix(1, 0))
+	})
+
+	fail := func(err error) (*Response, error) {
+		stop()
+		pc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	err := writeRequest(pc.writer, req, pc.host)
+	if err != nil {
+		return fail(err)
+	}
+
+	head, err := response.ReadHead(pc.reader, req.RequestLine.Method)
+	if err != nil {
+		return fail(err)
+	}
+
+	res := &Response{
+		StatusCode: int(head.StatusLine.StatusCode),
+		Reason:     head.StatusLine.ReasonPhrase,
+		Proto:      "HTTP/" + head.StatusLine.HttpVersion,
+		Headers:    head.Headers,
+		Trailers:   head.Trailers,
+	}
+
+	reader, delimited := head.BodyReader()
+	if res.StatusCode == response.StatusCodeSwitchingProtocols {
+		// The connection now speaks another protocol, handed over as the body
+		reader, delimited = pc.reader, false
+	}
+
+	b := &body{
+		reader:   reader,
+		conn:     pc,
+		client:   c,
+		reusable: delimited && keepAlive(req, res),
+		stop:     stop,
+	}
+	if reader == nil {
+		b.reader = strings.NewReader("")
+		b.finish(b.reusable)
+	}
+	res.Body = b
+	return res, nil
+}
+
+// writeRequest sends req, filling in Host when it is missing
+func writeRequest(w *bufio.Writer, req *request.Request, host string) error {
+	if _, exists := req.Headers.Get("Host"); !exists {
+		withHost := *req
+		withHost.Headers = maps.Clone(req.Headers)
+		withHost.Headers.Overwrite("Host", host)
+		req = &withHost
+	}
+
+	_, err := req.WriteTo(w)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (b *body) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.done {
+		b.finish(false)
+	}
+	return nil
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.done {
+		return 0, io.EOF
+	}
+
+	n, err := b.reader.Read(p)
+	if err == io.EOF {
+		b.finish(b.reusable)
+	} else if err != nil {
+		b.finish(false)
+	}
+	return n, err
+}
+
+func (b *body) finish(reuse bool) {
+	b.done = true
+	if !b.stop() {
+		// The context fired, so the connection carries a deadline in the past
+		reuse = false
+	}
+
+	if reuse {
+		b.client.release(b.conn)
+		return
+	}
+	b.conn.Close()
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func keepAlive(req *request.Request, res *Response) bool {
+	if res.Proto != "HTTP/1.1" {
+		return false
+	}
+
+	for _, h := range []headers.Headers{req.Headers, res.Headers} {
+		connection, _ := h.Get("Connection")
+		for _, token := range strings.Split(connection, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "close") {
+				return false
+			}
+		}
+	}
+	return true
+}
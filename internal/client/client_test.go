@@ -0,0 +1,288 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawServer answers every request on a connection with the next of the given raw responses, and
+// counts the connections it accepted
+func rawServer(t *testing.T, responses ...string) (string, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var accepted atomic.Int32
+	var next atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					_, err := request.FromReader(&headReader{r: reader})
+					if err != nil {
+						return
+					}
+
+					i := int(next.Add(1)) - 1
+					if i >= len(responses) {
+						return
+					}
+
+					_, err = conn.Write([]byte(responses[i]))
+					if err != nil || strings.Contains(responses[i], "Connection: close") {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), &accepted
+}
+
+// headReader stops at the blank line ending a request head, so FromReader does not wait for more
+type headReader struct {
+	r       *bufio.Reader
+	pending string
+	done    bool
+}
+
+func (h *headReader) Read(p []byte) (int, error) {
+	if h.pending == "" {
+		if h.done {
+			return 0, io.EOF
+		}
+
+		line, err := h.r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		h.pending = line
+		h.done = line == "\r\n"
+	}
+
+	n := copy(p, h.pending)
+	h.pending = h.pending[n:]
+	return n, nil
+}
+
+func TestClient(t *testing.T) {
+	origin, accepted := rawServer(t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5\r\nworld\r\n1;ext=1\r\n!\r\n0\r\nX-Sum: 42\r\n\r\n",
+		"HTTP/1.1 204 No Content\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nuntil the end",
+	)
+
+	c := NewClient()
+	defer c.Close()
+	ctx := context.Background()
+
+	// Test: Content-Length body
+	res, err := c.Get(ctx, origin+"/one")
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "OK", res.Reason)
+	data, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Test: Interim response skipped, chunked body with extensions and trailers
+	res, err = c.Get(ctx, origin+"/two")
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	data, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "world!", string(data))
+	assert.Equal(t, "42", res.Trailers["x-sum"])
+
+	// Test: No body
+	res, err = c.Get(ctx, origin+"/three")
+	require.NoError(t, err)
+	assert.Equal(t, 204, res.StatusCode)
+	data, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	// Test: Close-delimited body
+	res, err = c.Get(ctx, origin+"/four")
+	require.NoError(t, err)
+	data, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(data))
+
+	// Test: Every response up to the close reused the first connection
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestClientTruncatedBody(t *testing.T) {
+	origin, _ := rawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\nConnection: close\r\n\r\nshort")
+
+	c := NewClient()
+	defer c.Close()
+
+	res, err := c.Get(context.Background(), origin+"/")
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestClientContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	c := NewClient()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = c.Get(ctx, "http://"+listener.Addr().String()+"/")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClientIdleConns(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	closed := make(chan struct{}, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					// FromReader takes a clean EOF for an empty request, so watch for the close here
+					_, err := reader.Peek(1)
+					if err != nil {
+						closed <- struct{}{}
+						return
+					}
+					_, _ = request.FromReader(&headReader{r: reader})
+					_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				}
+			}()
+		}
+	}()
+	origin := "http://" + listener.Addr().String()
+
+	get := func(c *Client) {
+		res, err := c.Get(context.Background(), origin+"/")
+		require.NoError(t, err)
+		_, err = io.ReadAll(res.Body)
+		require.NoError(t, err)
+	}
+	waitClosed := func() {
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("idle connection left open")
+		}
+	}
+
+	// Test: A connection idle past the timeout is closed without another request coming along
+	c := NewClient(WithIdleTimeout(50 * time.Millisecond))
+	defer c.Close()
+	get(c)
+	waitClosed()
+	c.mu.Lock()
+	assert.Empty(t, c.idle)
+	c.mu.Unlock()
+
+	// Test: Close shuts down pooled connections
+	c = NewClient()
+	get(c)
+	require.NoError(t, c.Close())
+	waitClosed()
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		body := fmt.Sprintf("%s %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body)
+		err := w.WriteStatusLine(response.StatusCodeOK)
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError, Err: err}
+		}
+
+		h := headers.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Overwrite("Transfer-Encoding", "chunked")
+		err = w.WriteHeaders(h)
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError, Err: err}
+		}
+
+		_, err = w.WriteChunkedBody([]byte(body))
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError, Err: err}
+		}
+
+		_, err = w.WriteChunkedBodyDone()
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError, Err: err}
+		}
+
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Done", "yes")
+		err = w.WriteTrailers(trailers)
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError, Err: err}
+		}
+		return nil
+	}
+
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c := NewClient()
+	defer c.Close()
+
+	req := request.NewRequest("POST", "/submit?x=1", []byte("payload"))
+	res, err := c.Do(context.Background(), "http://"+srv.Addr().String(), req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+	data, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /submit?x=1 payload", string(data))
+	assert.Equal(t, "yes", res.Trailers["x-done"])
+}
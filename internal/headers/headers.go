@@ -5,8 +5,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 type Headers map[string]string
 
 func NewHeaders() Headers {
@@ -78,3 +81,14 @@ func GetDefaultHeaders(contentLen int) Headers {
 	h.Set("Content-Type", "plain/text")
 	return h
 }
+
+func ParseTime(val string) (time.Time, error) {
+	layouts := []string{TimeFormat, time.RFC850, time.ANSIC}
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, val)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid HTTP date")
+}
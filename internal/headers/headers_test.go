@@ -74,3 +74,30 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestParseQualityValues(t *testing.T) {
+	// Test: Ordered by quality, ties keep their order
+	values := ParseQualityValues("text/html;q=0.8, application/json, text/plain;q=0.8, */*;q=0.1")
+	assert.Equal(t, []QualityValue{
+		{Value: "application/json", Quality: 1},
+		{Value: "text/html", Quality: 0.8},
+		{Value: "text/plain", Quality: 0.8},
+		{Value: "*/*", Quality: 0.1},
+	}, values)
+
+	// Test: Other parameters are dropped and values are lower cased
+	values = ParseQualityValues("Text/HTML; level=1; q=0.5")
+	assert.Equal(t, []QualityValue{{Value: "text/html", Quality: 0.5}}, values)
+
+	// Test: Invalid quality values are treated as unacceptable
+	values = ParseQualityValues("gzip;q=2, br;q=abc, deflate")
+	assert.Equal(t, []QualityValue{
+		{Value: "deflate", Quality: 1},
+		{Value: "gzip", Quality: 0},
+		{Value: "br", Quality: 0},
+	}, values)
+
+	// Test: Empty members are skipped
+	values = ParseQualityValues(" , en-US,,")
+	assert.Equal(t, []QualityValue{{Value: "en-us", Quality: 1}}, values)
+}
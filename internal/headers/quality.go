@@ -0,0 +1,55 @@
+package headers
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type QualityValue struct {
+	Value   string
+	Quality float64
+}
+
+// ParseQualityValues parses a list like Accept or Accept-Language into its members ordered by
+// descending quality. Members with the same quality keep the order the client sent them in, and
+// parameters other than q are dropped
+func ParseQualityValues(val string) []QualityValue {
+	values := make([]QualityValue, 0)
+
+	for _, member := range strings.Split(val, ",") {
+		parts := strings.Split(member, ";")
+		value := strings.ToLower(strings.TrimSpace(parts[0]))
+		if value == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range parts[1:] {
+			key, v, found := strings.Cut(param, "=")
+			if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			quality = q
+		}
+
+		values = append(values, QualityValue{Value: value, Quality: quality})
+	}
+
+	slices.SortStableFunc(values, func(a, b QualityValue) int {
+		switch {
+		case a.Quality > b.Quality:
+			return -1
+		case a.Quality < b.Quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return values
+}
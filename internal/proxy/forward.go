@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/client"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+)
+
+const DefaultDialTimeout = 10 * time.Second
+
+var ErrForbiddenDestination = errors.New("destination not allowed")
+
+// reservedPrefixes are reached through this machine or its provider without being covered by the
+// netip.Addr classification methods
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+type ForwardOption func(*forwardProxy)
+
+type forwardProxy struct {
+	allowed      []string
+	denied       []string
+	dialTimeout  time.Duration
+	allowPrivate bool
+	control      func(network, address string, c syscall.RawConn) error
+	client       *client.Client
+}
+
+// WithAllowedHosts limits destinations to the given hosts. A pattern starting with "*." matches any
+// subdomain, anything else must match the host exactly
+func WithAllowedHosts(patterns ...string) ForwardOption {
+	return func(p *forwardProxy) {
+		p.allowed = append(p.allowed, patterns...)
+	}
+}
+
+// WithDeniedHosts rejects the given hosts, taking precedence over the allow list
+func WithDeniedHosts(patterns ...string) ForwardOption {
+	return func(p *forwardProxy) {
+		p.denied = append(p.denied, patterns...)
+	}
+}
+
+// WithPrivateDestinations lets clients reach loopback, private and link-local addresses, which are
+// otherwise refused once the destination has been resolved
+func WithPrivateDestinations() ForwardOption {
+	return func(p *forwardProxy) {
+		p.allowPrivate = true
+	}
+}
+
+func WithDialTimeout(timeout time.Duration) ForwardOption {
+	return func(p *forwardProxy) {
+		p.dialTimeout = timeout
+	}
+}
+
+// Forward returns a handler for clients using this server as their proxy. CONNECT requests are
+// tunneled to the target, absolute-form requests for plain http URLs are forwarded upstream. Unless
+// WithPrivateDestinations is given, only public addresses can be reached
+func Forward(opts ...ForwardOption) server.Handler {
+	p := &forwardProxy{
+		dialTimeout: DefaultDialTimeout,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if !p.allowPrivate {
+		p.control = publicOnly
+	}
+	p.client = client.NewClient(client.WithDialTimeout(p.dialTimeout), client.WithDialControl(p.control))
+
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if req.RequestLine.Method == "CONNECT" {
+			return p.tunnel(w, req)
+		}
+		return p.forward(w, req)
+	}
+}
+
+func (p *forwardProxy) forward(w *response.Writer, req *request.Request) *server.HandlerError {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || target.Scheme != "http" || target.Host == "" {
+		return &server.HandlerError{
+			StatusCode: response.StatusCodeBadRequest,
+			Message:    "expected an absolute http URL, use CONNECT for https",
+		}
+	}
+
+	if !p.permitted(target.Hostname()) {
+		return &server.HandlerError{StatusCode: response.StatusCodeForbidden, Message: "destination not allowed"}
+	}
+
+	outReq := outgoingRequest(req, target.RequestURI())
+	outReq.Headers.Overwrite("Host", target.Host)
+
+	res, err := p.client.Do(req.Context(), "http://"+target.Host, outReq)
+	if err != nil {
+		return upstreamError(err)
+	}
+	defer res.Body.Close()
+
+	err = writeResponse(w, res)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusCodeBadGateway, Err: err}
+	}
+	return nil
+}
+
+// permitted checks host against the deny list and then, when one is configured, the allow list
+func (p *forwardProxy) permitted(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	for _, pattern := range p.denied {
+		if matchHost(pattern, host) {
+			return false
+		}
+	}
+
+	if len(p.allowed) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.allowed {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *forwardProxy) tunnel(w *response.Writer, req *request.Request) *server.HandlerError {
+	host, _, err := net.SplitHostPort(req.RequestLine.RequestTarget)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusCodeBadRequest, Err: err}
+	}
+
+	if !p.permitted(host) {
+		return &server.HandlerError{StatusCode: response.StatusCodeForbidden, Message: "destination not allowed"}
+	}
+
+	dialer := &net.Dialer{Timeout: p.dialTimeout, Control: p.control}
+	upstream, err := dialer.DialContext(req.Context(), "tcp", req.RequestLine.RequestTarget)
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError, Err: err}
+	}
+
+	// A 2xx answer to CONNECT must not carry framing headers, so it is written past the Writer and
+	// whatever middleware wraps it
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err != nil {
+		log.Println(err)
+		conn.Close()
+		upstream.Close()
+		return nil
+	}
+
+	pipe(req.Context(), conn, buffered, upstream)
+	return nil
+}
+
+func matchHost(pattern, host string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+// publicOnly refuses to connect to this machine or the networks around it. It sees the resolved
+// address, so names pointing there and spellings such as 127.1 or ::ffff:127.0.0.1 are caught too
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := addrPort.Addr().Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return fmt.Errorf("%w: %s", ErrForbiddenDestination, ip)
+	}
+
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenDestination, ip)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermitted(t *testing.T) {
+	p := &forwardProxy{
+		allowed: []string{"example.com", "*.internal.dev"},
+		denied:  []string{"secret.internal.dev"},
+	}
+
+	assert.True(t, p.permitted("example.com"))
+	assert.True(t, p.permitted("EXAMPLE.com."))
+	assert.True(t, p.permitted("api.internal.dev"))
+	assert.False(t, p.permitted("internal.dev"))
+	assert.False(t, p.permitted("secret.internal.dev"))
+	assert.False(t, p.permitted("www.example.com"))
+
+	// Test: Without an allow list only denied hosts are rejected
+	p = &forwardProxy{denied: []string{"localhost"}}
+	assert.True(t, p.permitted("example.org"))
+	assert.False(t, p.permitted("localhost"))
+}
+
+func TestTunnel(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	// The first bytes of the tunnel arrive along with the CONNECT request
+	req, err := request.FromReader(strings.NewReader("CONNECT " + upstream.Addr().String() + " HTTP/1.1\r\nHost: " + upstream.Addr().String() + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	w := response.NewWriter(serverConn)
+	w.SetBuffered([]byte("early "))
+
+	done := make(chan *server.HandlerError)
+	go func() {
+		done <- Forward(WithPrivateDestinations())(w, req)
+	}()
+
+	reader := bufio.NewReader(clientConn)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+
+	_, err = clientConn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	echoed := make([]byte, len("early ping"))
+	_, err = io.ReadFull(reader, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "early ping", string(echoed))
+
+	clientConn.Close()
+	assert.Nil(t, <-done)
+
+	// Test: Denied destinations are refused before dialing
+	w = response.NewWriter(io.Discard)
+	hErr := Forward(WithDeniedHosts("127.0.0.1"))(w, req)
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeForbidden), hErr.StatusCode)
+}
+
+func TestForwardAbsoluteForm(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/teapot", r.URL.Path)
+		assert.Equal(t, "q=1", r.URL.RawQuery)
+		assert.Equal(t, "yes", r.Header.Get("X-Custom"))
+		assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+		assert.Empty(t, r.Header.Get("X-Hop"))
+
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("short and stout"))
+	}))
+	defer upstream.Close()
+
+	raw := "GET " + upstream.URL + "/teapot?q=1 HTTP/1.1\r\n" +
+		"Host: " + strings.TrimPrefix(upstream.URL, "http://") + "\r\n" +
+		"X-Custom: yes\r\n" +
+		"X-Hop: drop me\r\n" +
+		"Connection: X-Hop\r\n" +
+		"Proxy-Authorization: Basic Zm9vOmJhcg==\r\n\r\n"
+	req, err := request.FromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var buffer strings.Builder
+	w := response.NewWriter(&buffer)
+	hErr := Forward(WithPrivateDestinations())(w, req)
+	require.Nil(t, hErr)
+	require.NoError(t, w.Flush())
+
+	out := buffer.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 418 \r\n"))
+	assert.Contains(t, out, "x-upstream: 1\r\n")
+	assert.Contains(t, out, "content-length: 15\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nshort and stout"))
+}
+
+func TestPublicOnly(t *testing.T) {
+	for _, address := range []string{
+		"127.0.0.2:80",
+		"[::ffff:127.0.0.1]:80",
+		"[::1]:80",
+		"0.0.0.0:80",
+		"0.1.2.3:80",
+		"10.1.2.3:80",
+		"172.16.0.1:80",
+		"192.168.1.1:80",
+		"100.64.0.1:80",
+		"169.254.169.254:80",
+		"[fe80::1%eth0]:80",
+		"[fd00::1]:80",
+		"224.0.0.1:80",
+	} {
+		// Test: Addresses inside this machine or its networks are refused
+		assert.ErrorIs(t, publicOnly("tcp", address, nil), ErrForbiddenDestination, address)
+	}
+
+	for _, address := range []string{"93.184.215.14:443", "[2606:4700::1111]:80"} {
+		// Test: Public addresses are allowed
+		assert.NoError(t, publicOnly("tcp", address, nil), address)
+	}
+}
+
+func TestForwardPrivateDestinations(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	_, port, err := net.SplitHostPort(upstream.Addr().String())
+	require.NoError(t, err)
+
+	forward := Forward()
+	for _, host := range []string{"127.0.0.2", "127.1", "0.0.0.0", "[::ffff:127.0.0.1]", "[0:0:0:0:0:0:0:1]", "localhost"} {
+		// Test: Tunnels to other spellings of this machine are refused on the resolved address
+		target := host + ":" + port
+		req, err := request.FromReader(strings.NewReader("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n"))
+		require.NoError(t, err)
+		hErr := forward(response.NewWriter(io.Discard), req)
+		require.NotNil(t, hErr, host)
+		if !errors.Is(hErr, ErrForbiddenDestination) {
+			// Resolvers that do not understand the spelling never reach an address at all
+			var dnsErr *net.DNSError
+			require.ErrorAs(t, hErr, &dnsErr, host)
+			continue
+		}
+		assert.Equal(t, response.StatusCode(response.StatusCodeForbidden), hErr.StatusCode, host)
+
+		// Test: So are absolute-form requests
+		req, err = request.FromReader(strings.NewReader("GET http://" + target + "/ HTTP/1.1\r\nHost: " + target + "\r\n\r\n"))
+		require.NoError(t, err)
+		hErr = forward(response.NewWriter(io.Discard), req)
+		require.NotNil(t, hErr, host)
+		assert.Equal(t, response.StatusCode(response.StatusCodeForbidden), hErr.StatusCode, host)
+	}
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/client"
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+)
+
+// hopHeaders only apply to a single connection and are never forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes the standard hop-by-hop headers and any others named in Connection
+func removeHopHeaders(h headers.Headers) {
+	connection, _ := h.Get("Connection")
+	for _, key := range strings.Split(connection, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			h.Delete(key)
+		}
+	}
+
+	for _, key := range hopHeaders {
+		h.Delete(key)
+	}
+}
+
+// upstreamError maps a failure to reach the upstream onto 504 for timeouts and 502 otherwise
+func upstreamError(err error) *server.HandlerError {
+	if errors.Is(err, ErrForbiddenDestination) {
+		return &server.HandlerError{StatusCode: response.StatusCodeForbidden, Message: "destination not allowed", Err: err}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &server.HandlerError{StatusCode: response.StatusCodeGatewayTimeout, Err: err}
+	}
+	return &server.HandlerError{StatusCode: response.StatusCodeBadGateway, Err: err}
+}
+
+// writeResponse relays an upstream response. Bodies of known length keep their Content-Length,
+// anything else is streamed chunked so upstream trailers can follow
+func writeResponse(w *response.Writer, res *client.Response) error {
+	code := response.StatusCode(res.StatusCode)
+	err := w.WriteStatusLine(code)
+	if err != nil {
+		return err
+	}
+
+	h := maps.Clone(res.Headers)
+	trailer, _ := h.Get("Trailer")
+	contentLength, hasLength := h.Get("Content-Length")
+	_, hasEncoding := h.Get("Transfer-Encoding")
+	removeHopHeaders(h)
+	h.Overwrite("Connection", "close")
+
+	if !response.BodyAllowed(code) {
+		return w.WriteHeaders(h)
+	}
+
+	chunked := hasEncoding || !hasLength || trailer != ""
+	if chunked {
+		h.Delete("Content-Length")
+		h.Overwrite("Transfer-Encoding", "chunked")
+		if trailer != "" {
+			h.Overwrite("Trailer", trailer)
+		}
+	} else {
+		h.Overwrite("Content-Length", contentLength)
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	contentType, _ := h.Get("Content-Type")
+	if chunked || strings.HasPrefix(contentType, "text/event-stream") {
+		err = copyFlushing(w, res.Body)
+	} else {
+		_, err = io.Copy(w, res.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !chunked {
+		return nil
+	}
+
+	_, err = w.WriteChunkedBodyDone()
+	if err != nil {
+		return err
+	}
+	return w.WriteTrailers(res.Trailers)
+}
+
+// copyFlushing copies body to w, flushing after every read so event streams and slow chunked
+// upstreams reach the client as they arrive rather than when the buffer fills
+func copyFlushing(w *response.Writer, body io.Reader) error {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			_, writeErr := w.Write(buffer[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+
+			writeErr = w.Flush()
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// outgoingRequest copies req for an upstream, minus hop-by-hop headers and the original framing
+func outgoingRequest(req *request.Request, target string) *request.Request {
+	out := request.NewRequest(req.RequestLine.Method, target, req.Body)
+	out.Headers = maps.Clone(req.Headers)
+	removeHopHeaders(out.Headers)
+	out.Headers.Delete("Content-Length")
+	return out
+}
+
+// pipe copies bytes both ways between conn and upstream until both sides are done or ctx is
+// cancelled, then closes both connections. buffered holds client bytes read before the hijack
+func pipe(ctx context.Context, conn net.Conn, buffered []byte, upstream net.Conn) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyHalf(upstream, io.MultiReader(bytes.NewReader(buffered), conn))
+	}()
+	go func() {
+		defer wg.Done()
+		copyHalf(conn, upstream)
+	}()
+	wg.Wait()
+
+	conn.Close()
+	upstream.Close()
+}
+
+// copyHalf copies src into dst and then half-closes dst, so the peer sees EOF while the other
+// direction keeps flowing
+func copyHalf(dst net.Conn, src io.Reader) {
+	_, _ = io.Copy(dst, src)
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = closer.CloseWrite()
+	}
+}
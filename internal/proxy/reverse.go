@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/client"
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+)
+
+type ReverseOption func(*reverseProxy)
+
+type reverseProxy struct {
+	upstreams    []*url.URL
+	next         atomic.Uint64
+	stripPrefix  string
+	preserveHost bool
+	client       *client.Client
+}
+
+// WithPreserveHost forwards the client's Host header instead of the upstream's
+func WithPreserveHost() ReverseOption {
+	return func(p *reverseProxy) {
+		p.preserveHost = true
+	}
+}
+
+func WithStripPrefix(prefix string) ReverseOption {
+	return func(p *reverseProxy) {
+		p.stripPrefix = prefix
+	}
+}
+
+func WithClient(c *client.Client) ReverseOption {
+	return func(p *reverseProxy) {
+		p.client = c
+	}
+}
+
+// Reverse returns a handler forwarding every request to one of the upstream base URLs, picked round
+// robin. The request path is appended to the upstream's path once any stripped prefix is removed
+func Reverse(upstreams []string, opts ...ReverseOption) (server.Handler, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstreams configured")
+	}
+
+	p := &reverseProxy{
+		client: client.NewClient(),
+	}
+
+	for _, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return nil, err
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid upstream: %s", upstream)
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p.serve, nil
+}
+
+func (p *reverseProxy) serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	target, found := strings.CutPrefix(req.RequestLine.RequestTarget, p.stripPrefix)
+	if !found || (target != "" && !strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "?")) {
+		return &server.HandlerError{StatusCode: response.StatusCodeNotFound}
+	}
+
+	upstream := p.upstreams[(p.next.Add(1)-1)%uint64(len(p.upstreams))]
+	outReq := outgoingRequest(req, upstreamTarget(upstream, target))
+
+	host, _ := req.Headers.Get("Host")
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	setForwarded(outReq.Headers, req.RemoteAddr, host, proto)
+	if !p.preserveHost || host == "" {
+		outReq.Headers.Overwrite("Host", upstream.Host)
+	}
+
+	res, err := p.client.Do(req.Context(), upstream.Scheme+"://"+upstream.Host, outReq)
+	if err != nil {
+		return upstreamError(err)
+	}
+	defer res.Body.Close()
+
+	err = writeResponse(w, res)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusCodeBadGateway, Err: err}
+	}
+	return nil
+}
+
+// upstreamTarget joins the client's target onto the upstream's path as sent, so percent-encoding in
+// it survives untouched
+func upstreamTarget(upstream *url.URL, target string) string {
+	path, query, hasQuery := strings.Cut(target, "?")
+	joined := strings.TrimSuffix(upstream.EscapedPath(), "/") + "/" + strings.TrimPrefix(path, "/")
+	if hasQuery {
+		joined += "?" + query
+	}
+	return joined
+}
+
+// setForwarded appends this hop to X-Forwarded-For and Forwarded, and records the original host
+// and protocol the client used
+func setForwarded(h headers.Headers, remoteAddr, host, proto string) {
+	clientIP, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		clientIP = remoteAddr
+	}
+
+	forwardedFor := clientIP
+	if strings.Contains(clientIP, ":") {
+		forwardedFor = `"[` + clientIP + `]"`
+	}
+
+	element := "proto=" + proto
+	if clientIP != "" {
+		element = "for=" + forwardedFor + ";" + element
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	if host != "" {
+		element += ";host=" + quoteForwarded(host)
+		h.Overwrite("X-Forwarded-Host", host)
+	}
+	h.Overwrite("X-Forwarded-Proto", proto)
+	h.Set("Forwarded", element)
+}
+
+// quoteForwarded quotes values that are not plain tokens, such as a host with a port
+func quoteForwarded(val string) string {
+	if strings.ContainsAny(val, ":[]\"") {
+		return `"` + strings.ReplaceAll(val, `"`, `\"`) + `"`
+	}
+	return val
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "PATCH", r.Method)
+		assert.Equal(t, "/api/items/a%20b", r.URL.EscapedPath())
+		assert.Equal(t, "x=1&y=2", r.URL.RawQuery)
+		assert.Equal(t, `{"name":"kettle"}`, string(body))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Empty(t, r.Header.Get("Keep-Alive"))
+		assert.Equal(t, "10.0.0.1, 192.0.2.7", r.Header.Get("X-Forwarded-For"))
+		assert.Equal(t, "localhost:42069", r.Header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "http", r.Header.Get("X-Forwarded-Proto"))
+		assert.Equal(t, `for=192.0.2.7;proto=http;host="localhost:42069"`, r.Header.Get("Forwarded"))
+
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer upstream.Close()
+
+	handler, err := Reverse([]string{upstream.URL + "/api/"}, WithStripPrefix("/backend"))
+	require.NoError(t, err)
+
+	body := `{"name":"kettle"}`
+	req, err := request.FromReader(strings.NewReader("PATCH /backend/items/a%20b?x=1&y=2 HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Keep-Alive: timeout=5\r\n" +
+		"X-Forwarded-For: 10.0.0.1\r\n" +
+		"Content-Length: 17\r\n\r\n" + body))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.7:51234"
+
+	var buffer strings.Builder
+	w := response.NewWriter(&buffer)
+	hErr := handler(w, req)
+	require.Nil(t, hErr)
+	require.NoError(t, w.Flush())
+
+	// Test: Status, headers and trailers come back, hop-by-hop headers do not
+	out := buffer.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 \r\n"))
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, out, "trailer: X-Checksum\r\n")
+	assert.Contains(t, out, "connection: close\r\n")
+	assert.Contains(t, out, "7\r\ncreated\r\n0\r\n")
+	assert.True(t, strings.HasSuffix(out, "x-checksum: abc123\r\n\r\n"))
+
+	// Test: Requests outside the prefix are not forwarded
+	req, err = request.FromReader(strings.NewReader("GET /elsewhere HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	hErr = handler(response.NewWriter(io.Discard), req)
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeNotFound), hErr.StatusCode)
+
+	// Test: Unreachable upstream
+	upstream.Close()
+	req, err = request.FromReader(strings.NewReader("GET /backend/ HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	hErr = handler(response.NewWriter(io.Discard), req)
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeBadGateway), hErr.StatusCode)
+}
+
+// chanWriter hands every write it receives to a channel
+type chanWriter chan string
+
+func (c chanWriter) Write(data []byte) (int, error) {
+	c <- string(data)
+	return len(data), nil
+}
+
+func TestReverseStreaming(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: one\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("data: two\n\n"))
+	}))
+	defer upstream.Close()
+
+	handler, err := Reverse([]string{upstream.URL})
+	require.NoError(t, err)
+
+	req, err := request.FromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+
+	writes := make(chanWriter, 16)
+	done := make(chan *server.HandlerError, 1)
+	go func() {
+		w := response.NewWriter(writes)
+		hErr := handler(w, req)
+		_ = w.Flush()
+		done <- hErr
+	}()
+
+	// Test: An event reaches the client while the upstream is still holding the stream open
+	var out strings.Builder
+	timeout := time.After(5 * time.Second)
+	for !strings.Contains(out.String(), "data: one\n\n") {
+		select {
+		case data := <-writes:
+			out.WriteString(data)
+		case <-timeout:
+			close(release)
+			t.Fatalf("event was not flushed, got %q", out.String())
+		}
+	}
+	assert.NotContains(t, out.String(), "data: two")
+
+	close(release)
+	go func() {
+		for range writes {
+		}
+	}()
+	assert.Nil(t, <-done)
+}
+
+func TestReverseInvalidUpstream(t *testing.T) {
+	_, err := Reverse(nil)
+	require.Error(t, err)
+
+	_, err = Reverse([]string{"localhost:8080"})
+	require.Error(t, err)
+}
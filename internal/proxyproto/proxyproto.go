@@ -0,0 +1,320 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHeaderTimeout = 5 * time.Second
+
+	// maxV1Length is the longest v1 header allowed by the spec, CRLF included
+	maxV1Length    = 107
+	v2HeaderLength = 16
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+var ErrNoHeader = errors.New("proxy protocol header missing")
+
+type Listener struct {
+	net.Listener
+	trusted       []netip.Prefix
+	headerTimeout time.Duration
+}
+
+type Option func(*Listener)
+
+// WithTrustedSources only accepts headers from peers within prefixes, such as the load balancers.
+// Other peers keep their own address and any header they send is left for the HTTP parser to reject.
+// Without this option no peer is trusted
+func WithTrustedSources(prefixes ...netip.Prefix) Option {
+	return func(l *Listener) {
+		l.trusted = append(l.trusted, prefixes...)
+	}
+}
+
+func WithHeaderTimeout(d time.Duration) Option {
+	return func(l *Listener) {
+		l.headerTimeout = d
+	}
+}
+
+// NewListener reads a PROXY protocol v1 or v2 header at the start of every connection from a trusted
+// peer, so RemoteAddr reports the client the load balancer accepted the connection from
+func NewListener(inner net.Listener, opts ...Option) *Listener {
+	l := &Listener{Listener: inner, headerTimeout: DefaultHeaderTimeout}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		trusted: l.trustedPeer(conn.RemoteAddr()),
+		timeout: l.headerTimeout,
+	}, nil
+}
+
+func (l *Listener) trustedPeer(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn reads the header on first use rather than in Accept, so a slow peer only holds up its own
+// connection
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	trusted bool
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	source net.Addr
+	dest   net.Addr
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// ReadFrom writes straight to the connection to the load balancer, so copies from a file onto a
+// *net.TCPConn can still use sendfile through the wrapper
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+// WriteTo hands anything buffered past the header to w first, then copies the connection itself so
+// splice stays available
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.WriteTo(w)
+}
+
+// RemoteAddr is the client address from the header, or the peer's own when there was none
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr is the address the client connected to on the load balancer, when the header carried it
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.dest != nil {
+		return c.dest
+	}
+	return c.Conn.LocalAddr()
+}
+
+// NetConn returns the connection to the load balancer
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *Conn) readHeader() {
+	if !c.trusted {
+		return
+	}
+
+	if c.timeout > 0 {
+		err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		if err != nil {
+			c.err = err
+			return
+		}
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	c.err = c.parseHeader()
+	if c.err != nil {
+		c.err = fmt.Errorf("error reading proxy protocol header from %s: %w", c.Conn.RemoteAddr(), c.err)
+	}
+}
+
+func (c *Conn) parseHeader() error {
+	// The v1 prefix is as long as both versions have in common, so a short v1 header is never waited on
+	// for bytes it does not have
+	start, err := c.reader.Peek(len(v1Prefix))
+	if err != nil {
+		return unexpected(err)
+	}
+
+	if bytes.Equal(start, v1Prefix) {
+		return c.parseV1()
+	}
+
+	if !bytes.HasPrefix(v2Signature, start) {
+		return ErrNoHeader
+	}
+
+	start, err = c.reader.Peek(len(v2Signature))
+	if err != nil {
+		return unexpected(err)
+	}
+
+	if !bytes.Equal(start, v2Signature) {
+		return ErrNoHeader
+	}
+	return c.parseV2()
+}
+
+// parseV1 reads the text form, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func (c *Conn) parseV1() error {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Length {
+			return errors.New("v1 header too long")
+		}
+
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed v1 header: %q", line)
+	}
+
+	source, err := parseV1Address(fields[2], fields[4], fields[1] == "TCP6")
+	if err != nil {
+		return err
+	}
+
+	dest, err := parseV1Address(fields[3], fields[5], fields[1] == "TCP6")
+	if err != nil {
+		return err
+	}
+
+	c.source = source
+	c.dest = dest
+	return nil
+}
+
+func parseV1Address(ip, port string, v6 bool) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is6() != v6 || addr.Zone() != "" {
+		return nil, fmt.Errorf("invalid v1 address: %q", ip)
+	}
+
+	// Ports are decimal without leading zeros
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid v1 port: %q", port)
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(portNum))), nil
+}
+
+// parseV2 reads the binary form: signature, version and command, address family and transport, the
+// length of the rest, then the addresses followed by TLVs that are skipped
+func (c *Conn) parseV2() error {
+	header := make([]byte, v2HeaderLength)
+	_, err := io.ReadFull(c.reader, header)
+	if err != nil {
+		return unexpected(err)
+	}
+
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 {
+		return fmt.Errorf("unsupported v2 version: %d", version)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return unexpected(err)
+	}
+
+	switch command {
+	case 0x0:
+		// LOCAL, such as the load balancer's own health checks
+		return nil
+	case 0x1:
+	default:
+		return fmt.Errorf("unsupported v2 command: %d", command)
+	}
+
+	family, transport := header[13]>>4, header[13]&0x0f
+	if transport != 0x1 {
+		// Only stream transports carry HTTP, anything else keeps the peer's address
+		return nil
+	}
+
+	var ipLength int
+	switch family {
+	case 0x1:
+		ipLength = 4
+	case 0x2:
+		ipLength = 16
+	default:
+		return nil
+	}
+
+	if len(payload) < 2*ipLength+4 {
+		return errors.New("v2 address block too short")
+	}
+
+	sourceIP, _ := netip.AddrFromSlice(payload[:ipLength])
+	destIP, _ := netip.AddrFromSlice(payload[ipLength : 2*ipLength])
+	ports := payload[2*ipLength:]
+
+	c.source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(sourceIP, binary.BigEndian.Uint16(ports[0:2])))
+	c.dest = net.TCPAddrFromAddrPort(netip.AddrPortFrom(destIP, binary.BigEndian.Uint16(ports[2:4])))
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
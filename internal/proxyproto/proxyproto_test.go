@@ -0,0 +1,242 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// accept sends data over a fresh connection to a wrapped listener and returns the accepted side
+func accept(t *testing.T, data []byte, opts ...Option) net.Conn {
+	t.Helper()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewListener(inner, opts...)
+	t.Cleanup(func() { listener.Close() })
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	_, err = client.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// acceptTrusted is accept for a listener that trusts the loopback peer
+func acceptTrusted(t *testing.T, data []byte) net.Conn {
+	t.Helper()
+	return accept(t, data, WithTrustedSources(netip.MustParsePrefix("127.0.0.0/8")))
+}
+
+func v2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestV1(t *testing.T) {
+	// Test: TCP4 header
+	conn := acceptTrusted(t, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.1:443", conn.LocalAddr().String())
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+
+	// Test: TCP6 header
+	conn = acceptTrusted(t, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\n"))
+	assert.Equal(t, "[2001:db8::1]:1234", conn.RemoteAddr().String())
+
+	// Test: UNKNOWN keeps the peer's address
+	conn = acceptTrusted(t, []byte("PROXY UNKNOWN\r\nGET"))
+	assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:"))
+	rest, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", string(rest))
+
+	// Test: Invalid headers
+	for _, header := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+	} {
+		conn = acceptTrusted(t, []byte(header))
+		_, err = conn.Read(make([]byte, 8))
+		assert.Error(t, err, header)
+	}
+}
+
+func TestV2(t *testing.T) {
+	// Test: INET over stream with a TLV after the addresses
+	addresses := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb, 0x04, 0x00, 0x01, 0x00}
+	conn := acceptTrusted(t, append(v2Header(0x1, 0x11, addresses), "GET"...))
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.1:443", conn.LocalAddr().String())
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", string(rest))
+
+	// Test: INET6
+	addresses = make([]byte, 36)
+	copy(addresses, netip.MustParseAddr("2001:db8::1").AsSlice())
+	copy(addresses[16:], netip.MustParseAddr("2001:db8::2").AsSlice())
+	binary.BigEndian.PutUint16(addresses[32:], 1234)
+	binary.BigEndian.PutUint16(addresses[34:], 80)
+	conn = acceptTrusted(t, v2Header(0x1, 0x21, addresses))
+	assert.Equal(t, "[2001:db8::1]:1234", conn.RemoteAddr().String())
+
+	// Test: LOCAL keeps the peer's address
+	conn = acceptTrusted(t, v2Header(0x0, 0x00, nil))
+	assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:"))
+
+	// Test: Truncated address block
+	conn = acceptTrusted(t, v2Header(0x1, 0x11, []byte{192, 0, 2, 1}))
+	_, err = conn.Read(make([]byte, 8))
+	assert.Error(t, err)
+}
+
+func TestCopyPassthrough(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewListener(inner, WithTrustedSources(netip.MustParsePrefix("127.0.0.0/8")))
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: The wrapper keeps the copy fast paths of the socket underneath
+	require.Implements(t, (*io.ReaderFrom)(nil), conn)
+	require.Implements(t, (*io.WriterTo)(nil), conn)
+
+	// Test: WriteTo skips the header and keeps the bytes buffered with it
+	var rest strings.Builder
+	_, err = conn.(io.WriterTo).WriteTo(&rest)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest.String())
+
+	// Test: ReadFrom sends a file to the client
+	f, err := os.CreateTemp(t.TempDir(), "body")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("hello from a file")
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	n, err := conn.(io.ReaderFrom).ReadFrom(f)
+	require.NoError(t, err)
+	assert.Equal(t, int64(17), n)
+	require.NoError(t, conn.Close())
+
+	received, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "hello from a file", string(received))
+}
+
+func TestTrust(t *testing.T) {
+	// Test: A trusted peer must send a header
+	conn := acceptTrusted(t, []byte("GET / HTTP/1.1\r\n\r\n"))
+	_, err := conn.Read(make([]byte, 8))
+	require.ErrorIs(t, err, ErrNoHeader)
+
+	// Test: An untrusted peer cannot spoof its address and its bytes reach the reader untouched
+	data := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+	conn = accept(t, []byte(data), WithTrustedSources(netip.MustParsePrefix("10.0.0.0/8")))
+	assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:"))
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(rest))
+
+	// Test: Without trusted sources nobody is trusted
+	conn = accept(t, []byte(data))
+	assert.True(t, strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:"))
+	rest, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(rest))
+
+	// Test: Peers inside a trusted prefix are honoured
+	conn = accept(t, []byte(data), WithTrustedSources(netip.MustParsePrefix("127.0.0.0/8")))
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewListener(inner, WithHeaderTimeout(50*time.Millisecond), WithTrustedSources(netip.MustParsePrefix("127.0.0.0/8")))
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("PROXY TCP4"))
+	require.NoError(t, err)
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: A peer that stalls mid-header is cut off
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 8))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestShortV1Header(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewListener(inner, WithTrustedSources(netip.MustParsePrefix("127.0.0.0/8")))
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The peer sends the shortest possible header and then waits for a response
+	_, err = client.Write([]byte("PROXY UNKNOWN\r\n"))
+	require.NoError(t, err)
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: The header is read without waiting for more bytes
+	done := make(chan net.Addr)
+	go func() {
+		done <- conn.RemoteAddr()
+	}()
+	select {
+	case addr := <-done:
+		assert.True(t, strings.HasPrefix(addr.String(), "127.0.0.1:"))
+	case <-time.After(time.Second):
+		t.Fatal("stalled on a complete v1 header")
+	}
+}
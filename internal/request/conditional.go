@@ -0,0 +1,128 @@
+package request
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+)
+
+type Precondition int
+
+const (
+	PreconditionPassed Precondition = iota
+	PreconditionNotModified
+	PreconditionFailed
+)
+
+// EvaluatePreconditions checks the conditional headers against the current validators of the selected
+// representation, in the order given by RFC 9110 section 13.2.2
+func (r *Request) EvaluatePreconditions(etag string, lastModified time.Time) Precondition {
+	lastModified = lastModified.Truncate(time.Second)
+
+	if ifMatch, exists := r.Headers.Get("If-Match"); exists {
+		if !matchesAny(ifMatch, etag, true) {
+			return PreconditionFailed
+		}
+	} else if ifUnmodifiedSince, exists := r.Headers.Get("If-Unmodified-Since"); exists && !lastModified.IsZero() {
+		t, err := headers.ParseTime(ifUnmodifiedSince)
+		if err == nil && lastModified.After(t) {
+			return PreconditionFailed
+		}
+	}
+
+	safe := r.RequestLine.Method == "GET" || r.RequestLine.Method == "HEAD"
+
+	if ifNoneMatch, exists := r.Headers.Get("If-None-Match"); exists {
+		if !matchesAny(ifNoneMatch, etag, false) {
+			return PreconditionPassed
+		}
+		if safe {
+			return PreconditionNotModified
+		}
+		return PreconditionFailed
+	}
+
+	if ifModifiedSince, exists := r.Headers.Get("If-Modified-Since"); exists && safe && !lastModified.IsZero() {
+		t, err := headers.ParseTime(ifModifiedSince)
+		if err == nil && !lastModified.After(t) {
+			return PreconditionNotModified
+		}
+	}
+
+	return PreconditionPassed
+}
+
+// IfRange reports whether a Range header should be honored given the If-Range validator, if any
+func (r *Request) IfRange(etag string, lastModified time.Time) bool {
+	ifRange, exists := r.Headers.Get("If-Range")
+	if !exists {
+		return true
+	}
+
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		return etag != "" && ETagsMatch(ifRange, etag, true)
+	}
+
+	t, err := headers.ParseTime(ifRange)
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return lastModified.Truncate(time.Second).Equal(t)
+}
+
+func ETagsMatch(a, b string, strong bool) bool {
+	aWeak := strings.HasPrefix(a, "W/")
+	bWeak := strings.HasPrefix(b, "W/")
+	if strong && (aWeak || bWeak) {
+		return false
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func matchesAny(header, etag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return etag != ""
+	}
+
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range parseETags(header) {
+		if ETagsMatch(candidate, etag, strong) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseETags(header string) []string {
+	etags := make([]string, 0)
+
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			return etags
+		}
+
+		prefix := ""
+		if strings.HasPrefix(header, "W/") {
+			prefix = "W/"
+			header = header[2:]
+		}
+
+		if !strings.HasPrefix(header, "\"") {
+			return etags
+		}
+
+		end := strings.Index(header[1:], "\"")
+		if end == -1 {
+			return etags
+		}
+
+		etags = append(etags, prefix+header[:end+2])
+		header = header[end+2:]
+	}
+}
@@ -0,0 +1,91 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrBodyTooLarge        = errors.New("decompressed body too large")
+)
+
+func (r *Request) Decompress(maxSize int64) error {
+	header, exists := r.Headers.Get("Content-Encoding")
+	if !exists {
+		return nil
+	}
+
+	encodings := strings.Split(header, ",")
+	for _, encoding := range encodings {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding != "gzip" && encoding != "x-gzip" && encoding != "deflate" && encoding != "identity" {
+			return fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+		}
+	}
+
+	body := r.Body
+	for _, encoding := range slices.Backward(encodings) {
+		decoded, err := decode(strings.ToLower(strings.TrimSpace(encoding)), body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Delete("Content-Encoding")
+	r.Headers.Overwrite("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func decode(encoding string, data []byte, maxSize int64) ([]byte, error) {
+	var reader io.Reader
+	var err error
+
+	switch encoding {
+	case "identity":
+		reader = bytes.NewReader(data)
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		// RFC 9110 defines deflate as zlib-wrapped, but some clients send raw deflate streams
+		if isZlibHeader(data) {
+			reader, err = zlib.NewReader(bytes.NewReader(data))
+		} else {
+			reader = flate.NewReader(bytes.NewReader(data))
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s body: %w", encoding, err)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s body: %w", encoding, err)
+	}
+
+	if int64(len(decoded)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+
+	return decoded, nil
+}
+
+func isZlibHeader(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	return data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
@@ -0,0 +1,77 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+const DefaultMaxJSONSize = 1 << 20
+
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrJSONTooLarge         = errors.New("JSON body too large")
+)
+
+type JSONOption func(*jsonDecoder)
+
+type jsonDecoder struct {
+	maxSize               int
+	disallowUnknownFields bool
+}
+
+func DisallowUnknownFields() JSONOption {
+	return func(d *jsonDecoder) {
+		d.disallowUnknownFields = true
+	}
+}
+
+// WithMaxJSONSize caps the size of a body DecodeJSON will decode. The body has already been read by
+// then, so this does not limit reading it
+func WithMaxJSONSize(maxSize int) JSONOption {
+	return func(d *jsonDecoder) {
+		d.maxSize = maxSize
+	}
+}
+
+func DecodeJSON(req *Request, v any, opts ...JSONOption) error {
+	d := &jsonDecoder{
+		maxSize: DefaultMaxJSONSize,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	contentType, _ := req.Headers.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !isJSON(mediaType) {
+		return fmt.Errorf("%w: %q", ErrUnsupportedMediaType, contentType)
+	}
+
+	if len(req.Body) > d.maxSize {
+		return ErrJSONTooLarge
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(req.Body))
+	if d.disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	err = decoder.Decode(v)
+	if err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	if decoder.Decode(&struct{}{}) != io.EOF {
+		return errors.New("invalid JSON body: unexpected data after top-level value")
+	}
+	return nil
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
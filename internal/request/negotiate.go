@@ -0,0 +1,119 @@
+package request
+
+import (
+	"strings"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+)
+
+// Negotiate picks the offered media type the client prefers according to Accept. It returns the first
+// offer when the header is absent and "" when none of the offers is acceptable
+func (r *Request) Negotiate(offers ...string) string {
+	return r.negotiate("Accept", offers, matchMediaType)
+}
+
+func (r *Request) NegotiateCharset(offers ...string) string {
+	return r.negotiate("Accept-Charset", offers, matchToken)
+}
+
+func (r *Request) NegotiateEncoding(offers ...string) string {
+	return r.negotiate("Accept-Encoding", offers, matchToken)
+}
+
+func (r *Request) NegotiateLanguage(offers ...string) string {
+	return r.negotiate("Accept-Language", offers, matchLanguage)
+}
+
+// PreferredEncoding picks the offered content coding an Accept-Encoding value prefers, for callers that
+// only have the header. A coding named explicitly outranks "*" and q=0 rules it out
+func PreferredEncoding(acceptEncoding string, offers ...string) string {
+	return negotiateHeader("Accept-Encoding", acceptEncoding, offers, matchToken)
+}
+
+func (r *Request) negotiate(key string, offers []string, match func(pattern, offer string) int) string {
+	if len(offers) == 0 {
+		return ""
+	}
+
+	header, exists := r.Headers.Get(key)
+	if !exists {
+		return offers[0]
+	}
+	return negotiateHeader(key, header, offers, match)
+}
+
+func negotiateHeader(key, header string, offers []string, match func(pattern, offer string) int) string {
+	accepted := headers.ParseQualityValues(header)
+	best := ""
+	bestQuality := 0.0
+
+	for _, offer := range offers {
+		normalized, _, _ := strings.Cut(strings.ToLower(offer), ";")
+		normalized = strings.TrimSpace(normalized)
+
+		quality := 0.0
+		specificity := -1
+		for _, a := range accepted {
+			s := match(a.Value, normalized)
+			if s > specificity {
+				specificity = s
+				quality = a.Quality
+			}
+		}
+
+		// Content codings other than identity must be asked for, identity is acceptable unless excluded
+		if specificity == -1 && key == "Accept-Encoding" && normalized == "identity" {
+			quality = 0.001
+		}
+
+		if quality > bestQuality {
+			best = offer
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+func matchLanguage(pattern, offer string) int {
+	if pattern == "*" {
+		return 0
+	}
+
+	if pattern == offer || strings.HasPrefix(offer, pattern+"-") {
+		return len(pattern)
+	}
+	return -1
+}
+
+func matchMediaType(pattern, offer string) int {
+	if pattern == "*/*" || pattern == "*" {
+		return 0
+	}
+
+	patternType, patternSubtype, _ := strings.Cut(pattern, "/")
+	offerType, offerSubtype, _ := strings.Cut(offer, "/")
+	if patternType != offerType {
+		return -1
+	}
+
+	if patternSubtype == "*" {
+		return 1
+	}
+
+	if patternSubtype == offerSubtype {
+		return 2
+	}
+	return -1
+}
+
+func matchToken(pattern, offer string) int {
+	if pattern == "*" {
+		return 0
+	}
+
+	if pattern == offer {
+		return 1
+	}
+	return -1
+}
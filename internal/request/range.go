@@ -0,0 +1,106 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const maxRanges = 100
+
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type Range struct {
+	Start  int64
+	Length int64
+}
+
+func (r Range) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header against a representation of the given size. Malformed or
+// unsupported headers yield no ranges so the caller serves the full representation instead
+func ParseRange(header string, size int64) ([]Range, error) {
+	unit, spec, found := strings.Cut(header, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" {
+		return nil, nil
+	}
+
+	parts := strings.Split(spec, ",")
+	if len(parts) > maxRanges {
+		return nil, nil
+	}
+
+	ranges := make([]Range, 0, len(parts))
+	specified := 0
+	var total int64
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		specified++
+
+		first, last, found := strings.Cut(part, "-")
+		if !found {
+			return nil, nil
+		}
+		first = strings.TrimSpace(first)
+		last = strings.TrimSpace(last)
+
+		if first == "" {
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, nil
+			}
+
+			if suffix == 0 || size == 0 {
+				continue
+			}
+
+			suffix = min(suffix, size)
+			ranges = append(ranges, Range{Start: size - suffix, Length: suffix})
+			total += suffix
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, nil
+			}
+		}
+
+		if start >= size {
+			continue
+		}
+
+		end = min(end, size-1)
+		ranges = append(ranges, Range{Start: start, Length: end - start + 1})
+		total += end - start + 1
+	}
+
+	if specified == 0 {
+		return nil, nil
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrRangeNotSatisfiable
+	}
+
+	// Requests asking for more bytes than the representation holds are more likely abuse than seeking
+	if total > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
@@ -1,9 +1,13 @@
 package request
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
@@ -13,10 +17,19 @@ import (
 
 type status int
 
+// DefaultMaxBodySize bounds the body FromReader accepts, whether framed by Content-Length or chunked
+const DefaultMaxBodySize = 10 << 20
+
+var ErrRequestBodyTooLarge = errors.New("request body too large")
+
 const (
 	requestStatusInitialized = iota
 	requestStatusParsingHeaders
 	requestStatusParsingBody
+	requestStatusParsingChunkSize
+	requestStatusParsingChunkData
+	requestStatusParsingChunkEnd
+	requestStatusParsingTrailers
 	requestStatusDone
 )
 
@@ -24,7 +37,22 @@ type Request struct {
 	RequestLine Line
 	Headers     headers.Headers
 	Body        []byte
+	Trailers    headers.Headers
+	RemoteAddr  string
+	Peer        *PeerCredentials
+	TLS         *tls.ConnectionState
 	status      status
+	ctx         context.Context
+	buffered    []byte
+	chunkLeft   int
+	maxBodySize int
+}
+
+// PeerCredentials identify the process on the other end of a Unix socket
+type PeerCredentials struct {
+	PID int
+	UID int
+	GID int
 }
 
 type Line struct {
@@ -34,10 +62,17 @@ type Line struct {
 }
 
 func FromReader(reader io.Reader) (*Request, error) {
+	return FromReaderWithLimit(reader, DefaultMaxBodySize)
+}
+
+// FromReaderWithLimit parses a request whose body may be at most maxBodySize bytes
+func FromReaderWithLimit(reader io.Reader, maxBodySize int) (*Request, error) {
 	request := &Request{
-		Headers: headers.NewHeaders(),
-		Body:    make([]byte, 0),
-		status:  requestStatusInitialized,
+		Headers:     headers.NewHeaders(),
+		Body:        make([]byte, 0),
+		Trailers:    headers.NewHeaders(),
+		status:      requestStatusInitialized,
+		maxBodySize: maxBodySize,
 	}
 	buffer := make([]byte, 8)
 	bytesRead := 0
@@ -72,9 +107,46 @@ func FromReader(reader io.Reader) (*Request, error) {
 		}
 	}
 
+	if bytesRead > 0 {
+		request.buffered = slices.Clone(buffer[:bytesRead])
+	}
 	return request, nil
 }
 
+// Buffered returns bytes read from the connection past the end of the request, such as the start of
+// a pipelined request or of a protocol the connection is being upgraded to
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// NewRequest builds a request to be sent by a client, rather than parsed off a connection
+func NewRequest(method, target string, body []byte) *Request {
+	return &Request{
+		RequestLine: Line{
+			Method:        method,
+			RequestTarget: target,
+			HttpVersion:   "1.1",
+		},
+		Headers:  headers.NewHeaders(),
+		Body:     body,
+		Trailers: headers.NewHeaders(),
+		status:   requestStatusDone,
+	}
+}
+
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	totalParsedBytes := 0
 
@@ -108,6 +180,19 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if done {
 			r.status = requestStatusParsingBody
 			n += 2
+
+			if transferEncoding, exists := r.Headers.Get("Transfer-Encoding"); exists {
+				if !isChunked(transferEncoding) {
+					return n, fmt.Errorf("unsupported transfer encoding: %s", transferEncoding)
+				}
+
+				// Intermediaries may frame the body by the other header, so the request is refused as a
+				// possible smuggling attempt (RFC 9112 section 6.3)
+				if _, exists := r.Headers.Get("Content-Length"); exists {
+					return n, errors.New("both Transfer-Encoding and Content-Length are set")
+				}
+				r.status = requestStatusParsingChunkSize
+			}
 		}
 
 		return n, err
@@ -120,23 +205,74 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		}
 
 		contentLength, err := strconv.Atoi(contentLengthHeader)
-		if err != nil {
-			return 0, fmt.Errorf("invalid content length: %w", err)
+		if err != nil || contentLength < 0 {
+			return 0, fmt.Errorf("invalid content length: %q", contentLengthHeader)
+		}
+
+		if contentLength > r.maxBodySize {
+			return 0, ErrRequestBodyTooLarge
 		}
 
-		r.Body = append(r.Body, data...)
+		// Bytes past the body belong to whatever follows on the connection
+		n := min(len(data), contentLength-len(r.Body))
+		r.Body = append(r.Body, data[:n]...)
 
 		if len(r.Body) == contentLength {
 			r.status = requestStatusDone
+		}
+		return n, nil
+
+	case requestStatusParsingChunkSize:
+		idx := strings.Index(string(data), "\r\n")
+		if idx == -1 {
 			return 0, nil
 		}
 
-		if len(r.Body) > contentLength {
-			return 0, errors.New("content length exceeded")
+		sizeStr, _, _ := strings.Cut(string(data[:idx]), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 32)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk size: %q", data[:idx])
 		}
 
-		return len(data), nil
+		if int64(len(r.Body))+size > int64(r.maxBodySize) {
+			return 0, ErrRequestBodyTooLarge
+		}
+
+		r.chunkLeft = int(size)
+		r.status = requestStatusParsingChunkData
+		if size == 0 {
+			r.status = requestStatusParsingTrailers
+		}
+		return idx + 2, nil
+	case requestStatusParsingChunkData:
+		n := min(len(data), r.chunkLeft)
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkLeft -= n
+
+		if r.chunkLeft == 0 {
+			r.status = requestStatusParsingChunkEnd
+		}
+		return n, nil
+	case requestStatusParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
 
+		if string(data[:2]) != "\r\n" {
+			return 0, errors.New("missing CRLF after chunk")
+		}
+
+		r.status = requestStatusParsingChunkSize
+		return 2, nil
+	case requestStatusParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+
+		if done {
+			r.status = requestStatusDone
+			n += 2
+		}
+
+		return n, err
 	case requestStatusDone:
 		return 0, errors.New("request already parsed")
 	default:
@@ -145,7 +281,7 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 }
 
 func parseRequestLine(data []byte) (int, *Line, error) {
-	validMethods := []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	validMethods := []string{"GET", "POST", "PUT", "PATCH", "DELETE", "CONNECT"}
 	str := string(data)
 
 	if !strings.Contains(str, "\r\n") {
@@ -164,7 +300,7 @@ func parseRequestLine(data []byte) (int, *Line, error) {
 		return 0, nil, fmt.Errorf("invalid method: %s", parts[0])
 	}
 
-	if !strings.HasPrefix(parts[1], "/") {
+	if !validRequestTarget(parts[0], parts[1]) {
 		return 0, nil, fmt.Errorf("invalid request target: %s", parts[1])
 	}
 
@@ -182,3 +318,29 @@ func parseRequestLine(data []byte) (int, *Line, error) {
 
 	return headerCount + 2, requestLine, nil
 }
+
+// isChunked reports whether the body is sent with chunked as its only transfer coding
+func isChunked(transferEncoding string) bool {
+	codings := strings.Split(transferEncoding, ",")
+	return len(codings) == 1 && strings.EqualFold(strings.TrimSpace(codings[0]), "chunked")
+}
+
+// validRequestTarget accepts the authority form for CONNECT, and the origin or absolute form for
+// everything else
+func validRequestTarget(method, target string) bool {
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" {
+			return false
+		}
+		_, err = strconv.ParseUint(port, 10, 16)
+		return err == nil
+	}
+
+	if strings.HasPrefix(target, "/") {
+		return true
+	}
+
+	u, err := url.Parse(target)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -1,9 +1,15 @@
 package request
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"io"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -60,6 +66,29 @@ func TestRequestLineParse(t *testing.T) {
 	_, err = FromReader(strings.NewReader("/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
 	require.Error(t, err)
 
+	// Test: CONNECT with an authority-form target
+	r, err = FromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	// Test: CONNECT without a port
+	_, err = FromReader(strings.NewReader("CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: CONNECT with an origin-form target
+	_, err = FromReader(strings.NewReader("CONNECT / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Absolute-form target
+	r, err = FromReader(strings.NewReader("GET http://example.com/coffee?q=1 HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/coffee?q=1", r.RequestLine.RequestTarget)
+
+	// Test: Absolute-form target with an unsupported scheme
+	_, err = FromReader(strings.NewReader("GET ftp://example.com/coffee HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.Error(t, err)
+
 	// Test: Standard Headers
 	reader = &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -170,3 +199,435 @@ func TestRequestLineParse(t *testing.T) {
 	r, err = FromReader(reader)
 	require.NoError(t, err)
 }
+
+func TestBuffered(t *testing.T) {
+	// Test: Bytes read past the headers are kept
+	reader := &chunkReader{
+		data: "GET /ws HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Upgrade: websocket\r\n" +
+			"\r\n" +
+			"\x81\x85frame",
+		numBytesPerRead: 64,
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "\x81\x85frame", string(r.Buffered()))
+
+	// Test: A Content-Length body is not buffered, only the pipelined bytes after it
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhelloGET",
+		numBytesPerRead: 64,
+	}
+	r, err = FromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Equal(t, "GET", string(r.Buffered()))
+
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 2,
+	}
+	r, err = FromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Empty(t, r.Buffered())
+
+	// Test: Nothing is buffered when the read ends with the request
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = FromReader(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Buffered())
+}
+
+func TestWriteTo(t *testing.T) {
+	roundTrip := func(r *Request) *Request {
+		var buffer bytes.Buffer
+		_, err := r.WriteTo(&buffer)
+		require.NoError(t, err)
+
+		parsed, err := FromReader(&chunkReader{data: buffer.String(), numBytesPerRead: 3})
+		require.NoError(t, err)
+		return parsed
+	}
+
+	// Test: Parsed request survives a round trip
+	r, err := FromReader(strings.NewReader("POST /submit?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello world!\n"))
+	require.NoError(t, err)
+	parsed := roundTrip(r)
+	assert.Equal(t, r.RequestLine, parsed.RequestLine)
+	assert.Equal(t, r.Headers, parsed.Headers)
+	assert.Equal(t, r.Body, parsed.Body)
+
+	// Test: Output is stable with Host first
+	var buffer bytes.Buffer
+	_, err = r.WriteTo(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /submit?x=1 HTTP/1.1\r\nhost: localhost:42069\r\ncontent-length: 13\r\ncontent-type: text/plain\r\n\r\nhello world!\n", buffer.String())
+
+	// Test: Content-Length follows the body rather than a stale header
+	r = NewRequest("PUT", "/items/1", []byte("updated"))
+	r.Headers.Set("Content-Length", "100")
+	parsed = roundTrip(r)
+	assert.Equal(t, "7", parsed.Headers["content-length"])
+	assert.Equal(t, "updated", string(parsed.Body))
+
+	// Test: GET without a body has no Content-Length
+	r = NewRequest("GET", "/", nil)
+	r.Headers.Set("Host", "localhost:42069")
+	parsed = roundTrip(r)
+	assert.NotContains(t, parsed.Headers, "content-length")
+	assert.Empty(t, parsed.Body)
+
+	// Test: Chunked body with trailers
+	r, err = FromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n6;ext=1\r\nworld!\r\n0\r\nX-Checksum: abc123\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+	assert.Equal(t, "abc123", r.Trailers["x-checksum"])
+
+	parsed = roundTrip(r)
+	assert.Equal(t, "hello world!", string(parsed.Body))
+	assert.Equal(t, r.Trailers, parsed.Trailers)
+	assert.Equal(t, "chunked", parsed.Headers["transfer-encoding"])
+
+	// Test: Pipelined bytes after a chunked body are kept
+	r, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET"))
+	require.NoError(t, err)
+	assert.Equal(t, "GET", string(r.Buffered()))
+
+	// Test: Unsupported transfer coding
+	_, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n"))
+	require.Error(t, err)
+}
+
+func TestBodyLimits(t *testing.T) {
+	// Test: Chunked bodies are capped as they accumulate
+	_, err := FromReaderWithLimit(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n6\r\nworld!\r\n0\r\n\r\n"), 8)
+	require.ErrorIs(t, err, ErrRequestBodyTooLarge)
+
+	r, err := FromReaderWithLimit(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n0\r\n\r\n"), 8)
+	require.NoError(t, err)
+	assert.Equal(t, "hello ", string(r.Body))
+
+	// Test: A declared Content-Length over the cap is refused before the body arrives
+	_, err = FromReaderWithLimit(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\nx"), 8)
+	require.ErrorIs(t, err, ErrRequestBodyTooLarge)
+
+	// Test: Transfer-Encoding together with Content-Length is rejected
+	_, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n0\r\n\r\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Content-Length")
+}
+
+func TestDecompress(t *testing.T) {
+	compress := func(encoding string, data string) string {
+		var buffer bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buffer)
+		case "deflate":
+			w = zlib.NewWriter(&buffer)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+		}
+		_, _ = w.Write([]byte(data))
+		_ = w.Close()
+		return buffer.String()
+	}
+
+	newRequest := func(encoding string, body string) *Request {
+		reader := &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Encoding: " + encoding + "\r\n" +
+				"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+				"\r\n" +
+				body,
+			numBytesPerRead: 3,
+		}
+		r, err := FromReader(reader)
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: gzip body
+	r := newRequest("gzip", compress("gzip", "hello world!\n"))
+	err := r.Decompress(1024)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "13", r.Headers["content-length"])
+	_, exists := r.Headers.Get("Content-Encoding")
+	assert.False(t, exists)
+
+	// Test: zlib wrapped deflate body
+	r = newRequest("deflate", compress("deflate", "hello world!\n"))
+	err = r.Decompress(1024)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Raw deflate body
+	r = newRequest("deflate", compress("raw-deflate", "hello world!\n"))
+	err = r.Decompress(1024)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Multiple encodings are decoded in reverse order
+	r = newRequest("deflate, gzip", compress("gzip", compress("deflate", "hello world!\n")))
+	err = r.Decompress(1024)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Unsupported encoding
+	r = newRequest("br", "hello world!\n")
+	err = r.Decompress(1024)
+	require.ErrorIs(t, err, ErrUnsupportedEncoding)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Decompressed body exceeds the limit
+	r = newRequest("gzip", compress("gzip", strings.Repeat("a", 4096)))
+	err = r.Decompress(1024)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Corrupt gzip body
+	r = newRequest("gzip", "definitely not gzip")
+	err = r.Decompress(1024)
+	require.Error(t, err)
+}
+
+func TestParseRange(t *testing.T) {
+	// Test: Single closed range
+	ranges, err := ParseRange("bytes=0-99", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{Start: 0, Length: 100}}, ranges)
+	assert.Equal(t, "bytes 0-99/1000", ranges[0].ContentRange(1000))
+
+	// Test: Open ended range
+	ranges, err = ParseRange("bytes=900-", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{Start: 900, Length: 100}}, ranges)
+
+	// Test: Suffix range
+	ranges, err = ParseRange("bytes=-100", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{Start: 900, Length: 100}}, ranges)
+
+	// Test: Suffix longer than the representation
+	ranges, err = ParseRange("bytes=-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{Start: 0, Length: 1000}}, ranges)
+
+	// Test: End past the representation is clamped
+	ranges, err = ParseRange("bytes=500-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{Start: 500, Length: 500}}, ranges)
+
+	// Test: Multiple ranges
+	ranges, err = ParseRange("bytes=0-9, 20-29,-10", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{Start: 0, Length: 10}, {Start: 20, Length: 10}, {Start: 990, Length: 10}}, ranges)
+
+	// Test: Unsatisfiable range
+	_, err = ParseRange("bytes=1000-", 1000)
+	require.ErrorIs(t, err, ErrRangeNotSatisfiable)
+
+	// Test: Unsatisfiable ranges are dropped when another one is satisfiable
+	ranges, err = ParseRange("bytes=2000-3000,0-0", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{Start: 0, Length: 1}}, ranges)
+
+	// Test: Invalid unit is ignored
+	ranges, err = ParseRange("items=0-10", 1000)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+
+	// Test: Malformed ranges are ignored
+	ranges, err = ParseRange("bytes=10-5", 1000)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+
+	ranges, err = ParseRange("bytes=abc", 1000)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+
+	// Test: Overlapping ranges larger than the representation are ignored
+	ranges, err = ParseRange("bytes=0-999,0-999", 1000)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+}
+
+func TestEvaluatePreconditions(t *testing.T) {
+	newRequest := func(method string, conditional string) *Request {
+		r, err := FromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: localhost:42069\r\n" + conditional + "\r\n"))
+		require.NoError(t, err)
+		return r
+	}
+
+	etag := "\"abc\""
+	lastModified := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	before := "Fri, 28 Feb 2025 12:00:00 GMT"
+	after := "Sun, 02 Mar 2025 12:00:00 GMT"
+
+	// Test: No conditional headers
+	r := newRequest("GET", "")
+	assert.Equal(t, PreconditionPassed, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-None-Match matching the current ETag
+	r = newRequest("GET", "If-None-Match: \"xyz\", \"abc\"\r\n")
+	assert.Equal(t, PreconditionNotModified, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-None-Match uses weak comparison
+	r = newRequest("GET", "If-None-Match: W/\"abc\"\r\n")
+	assert.Equal(t, PreconditionNotModified, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-None-Match on an unsafe method
+	r = newRequest("POST", "If-None-Match: *\r\n")
+	assert.Equal(t, PreconditionFailed, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	r = newRequest("GET", "If-None-Match: \"xyz\"\r\nIf-Modified-Since: "+after+"\r\n")
+	assert.Equal(t, PreconditionPassed, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-Modified-Since
+	r = newRequest("GET", "If-Modified-Since: "+after+"\r\n")
+	assert.Equal(t, PreconditionNotModified, r.EvaluatePreconditions(etag, lastModified))
+
+	r = newRequest("GET", "If-Modified-Since: "+before+"\r\n")
+	assert.Equal(t, PreconditionPassed, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-Match uses strong comparison
+	r = newRequest("PUT", "If-Match: \"abc\"\r\n")
+	assert.Equal(t, PreconditionPassed, r.EvaluatePreconditions(etag, lastModified))
+
+	r = newRequest("PUT", "If-Match: W/\"abc\"\r\n")
+	assert.Equal(t, PreconditionFailed, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	r = newRequest("PUT", "If-Match: \"abc\"\r\nIf-Unmodified-Since: "+before+"\r\n")
+	assert.Equal(t, PreconditionPassed, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-Unmodified-Since
+	r = newRequest("PUT", "If-Unmodified-Since: "+before+"\r\n")
+	assert.Equal(t, PreconditionFailed, r.EvaluatePreconditions(etag, lastModified))
+
+	r = newRequest("PUT", "If-Unmodified-Since: "+after+"\r\n")
+	assert.Equal(t, PreconditionPassed, r.EvaluatePreconditions(etag, lastModified))
+
+	// Test: If-Range with matching and stale validators
+	r = newRequest("GET", "If-Range: \"abc\"\r\n")
+	assert.True(t, r.IfRange(etag, lastModified))
+
+	r = newRequest("GET", "If-Range: W/\"abc\"\r\n")
+	assert.False(t, r.IfRange(etag, lastModified))
+
+	r = newRequest("GET", "If-Range: Sat, 01 Mar 2025 12:00:00 GMT\r\n")
+	assert.True(t, r.IfRange(etag, lastModified))
+
+	r = newRequest("GET", "If-Range: "+before+"\r\n")
+	assert.False(t, r.IfRange(etag, lastModified))
+}
+
+func TestNegotiate(t *testing.T) {
+	newRequest := func(header string) *Request {
+		r, err := FromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n" + header + "\r\n"))
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: Missing Accept picks the first offer
+	r := newRequest("")
+	assert.Equal(t, "text/html", r.Negotiate("text/html", "application/json"))
+
+	// Test: Highest quality wins
+	r = newRequest("Accept: text/html;q=0.5, application/json\r\n")
+	assert.Equal(t, "application/json", r.Negotiate("text/html", "application/json"))
+
+	// Test: Most specific range decides the quality
+	r = newRequest("Accept: text/*;q=0.9, text/plain;q=0.1, */*;q=0.5\r\n")
+	assert.Equal(t, "text/html", r.Negotiate("text/plain", "text/html"))
+	assert.Equal(t, "image/png", r.Negotiate("text/plain", "image/png"))
+
+	// Test: Nothing acceptable
+	r = newRequest("Accept: application/json\r\n")
+	assert.Equal(t, "", r.Negotiate("text/html", "text/plain"))
+
+	r = newRequest("Accept: text/html;q=0\r\n")
+	assert.Equal(t, "", r.Negotiate("text/html"))
+
+	// Test: Language prefixes
+	r = newRequest("Accept-Language: fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5\r\n")
+	assert.Equal(t, "fr", r.NegotiateLanguage("en-US", "fr"))
+	assert.Equal(t, "en-US", r.NegotiateLanguage("en-US", "de"))
+	assert.Equal(t, "de", r.NegotiateLanguage("de"))
+
+	// Test: Charsets
+	r = newRequest("Accept-Charset: iso-8859-1, utf-8;q=0.7\r\n")
+	assert.Equal(t, "iso-8859-1", r.NegotiateCharset("utf-8", "iso-8859-1"))
+
+	// Test: Identity encoding is acceptable unless excluded
+	r = newRequest("Accept-Encoding: gzip\r\n")
+	assert.Equal(t, "gzip", r.NegotiateEncoding("identity", "gzip"))
+	assert.Equal(t, "identity", r.NegotiateEncoding("br", "identity"))
+
+	r = newRequest("Accept-Encoding: gzip, identity;q=0\r\n")
+	assert.Equal(t, "", r.NegotiateEncoding("br", "identity"))
+}
+
+func TestDecodeJSON(t *testing.T) {
+	newRequest := func(contentType string, body string) *Request {
+		r, err := FromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Type: " + contentType + "\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+			"\r\n" +
+			body))
+		require.NoError(t, err)
+		return r
+	}
+
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	// Test: Valid body
+	var p payload
+	err := DecodeJSON(newRequest("application/json; charset=utf-8", `{"name":"Madhur"}`), &p)
+	require.NoError(t, err)
+	assert.Equal(t, "Madhur", p.Name)
+
+	// Test: Structured syntax suffix
+	err = DecodeJSON(newRequest("application/merge-patch+json", `{"name":"Preeti"}`), &p)
+	require.NoError(t, err)
+	assert.Equal(t, "Preeti", p.Name)
+
+	// Test: Wrong content type
+	err = DecodeJSON(newRequest("text/plain", `{"name":"Madhur"}`), &p)
+	require.ErrorIs(t, err, ErrUnsupportedMediaType)
+
+	// Test: Body over the size limit
+	err = DecodeJSON(newRequest("application/json", `{"name":"Madhur"}`), &p, WithMaxJSONSize(8))
+	require.ErrorIs(t, err, ErrJSONTooLarge)
+	assert.NotErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Unknown fields
+	err = DecodeJSON(newRequest("application/json", `{"name":"Madhur","age":3}`), &p)
+	require.NoError(t, err)
+
+	err = DecodeJSON(newRequest("application/json", `{"name":"Madhur","age":3}`), &p, DisallowUnknownFields())
+	require.Error(t, err)
+
+	// Test: Trailing data after the value
+	err = DecodeJSON(newRequest("application/json", `{"name":"Madhur"} {}`), &p)
+	require.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WriteTo serializes the request as an HTTP/1.1 message. Requests carrying Transfer-Encoding: chunked
+// are sent chunked with their trailers, anything else gets a Content-Length matching Body
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %s HTTP/1.1\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget)
+
+	h := maps.Clone(r.Headers)
+	transferEncoding, chunked := h.Get("Transfer-Encoding")
+	if chunked && !isChunked(transferEncoding) {
+		return 0, fmt.Errorf("unsupported transfer encoding: %s", transferEncoding)
+	}
+
+	if chunked {
+		h.Delete("Content-Length")
+	} else if len(r.Body) > 0 || hasBodySemantics(r.RequestLine.Method) {
+		h.Overwrite("Content-Length", strconv.Itoa(len(r.Body)))
+	} else {
+		h.Delete("Content-Length")
+	}
+
+	// Host goes first as clients conventionally send it, the rest in a stable order for replay
+	if host, exists := h.Get("Host"); exists {
+		buffer.WriteString("host: " + host + "\r\n")
+		h.Delete("Host")
+	}
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		buffer.WriteString(key + ": " + h[key] + "\r\n")
+	}
+	buffer.WriteString("\r\n")
+
+	if !chunked {
+		buffer.Write(r.Body)
+		return buffer.WriteTo(w)
+	}
+
+	if len(r.Body) > 0 {
+		fmt.Fprintf(&buffer, "%x\r\n", len(r.Body))
+		buffer.Write(r.Body)
+		buffer.WriteString("\r\n")
+	}
+	buffer.WriteString("0\r\n")
+	for _, key := range slices.Sorted(maps.Keys(r.Trailers)) {
+		buffer.WriteString(key + ": " + r.Trailers[key] + "\r\n")
+	}
+	buffer.WriteString("\r\n")
+	return buffer.WriteTo(w)
+}
+
+func hasBodySemantics(method string) bool {
+	switch strings.ToUpper(method) {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+)
+
+const (
+	compressMinSize = 1024
+	// compressMaxBuffered is the largest declared body held back to learn its compressed length. Larger
+	// ones are streamed chunked, as auto framing does past its threshold
+	compressMaxBuffered = DefaultFrameThreshold
+)
+
+var incompressibleTypes = []string{
+	"application/gzip",
+	"application/octet-stream",
+	"application/pdf",
+	"application/x-gzip",
+	"application/zip",
+	"audio/",
+	"font/woff",
+	"image/",
+	"multipart/byteranges",
+	"text/event-stream",
+	"video/",
+}
+
+type compression struct {
+	encoding  string
+	encoder   io.WriteCloser
+	buffer    bytes.Buffer
+	pending   headers.Headers
+	remaining int
+	streamed  bool
+}
+
+func (w *Writer) EnableCompression(acceptEncoding string) {
+	w.compression = &compression{
+		encoding: negotiateEncoding(acceptEncoding),
+	}
+}
+
+func (w *Writer) finishCompressedBody() error {
+	c := w.compression
+	err := c.encoder.Close()
+	if err != nil {
+		return err
+	}
+
+	h := c.pending
+	c.pending = nil
+	h.Overwrite("Content-Length", strconv.Itoa(c.buffer.Len()))
+
+	err = w.writeHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writer.Write(c.buffer.Bytes())
+	c.buffer.Reset()
+	return err
+}
+
+func (w *Writer) finishCompressedChunks() error {
+	err := w.compression.encoder.Close()
+	if err != nil {
+		return err
+	}
+	return w.writeCompressedChunk(nil)
+}
+
+// prepareCompression decides whether the body described by h gets compressed, and returns true when
+// the headers must be held back until the compressed Content-Length is known
+func (w *Writer) prepareCompression(h headers.Headers) bool {
+	c := w.compression
+	if !BodyAllowed(w.code) {
+		return false
+	}
+
+	if _, exists := h.Get("Content-Encoding"); exists {
+		return false
+	}
+
+	if _, exists := h.Get("Content-Range"); exists {
+		return false
+	}
+
+	contentType, _ := h.Get("Content-Type")
+	if !isCompressible(contentType) {
+		return false
+	}
+
+	addVary(h)
+	if c.encoding == "" {
+		return false
+	}
+
+	if transferEncoding, _ := h.Get("Transfer-Encoding"); strings.EqualFold(transferEncoding, "chunked") {
+		setEncoding(h, c.encoding)
+		c.encoder = newEncoder(c.encoding, &c.buffer)
+		return false
+	}
+
+	contentLengthHeader, exists := h.Get("Content-Length")
+	if !exists {
+		return false
+	}
+
+	contentLength, err := strconv.Atoi(contentLengthHeader)
+	if err != nil || contentLength < compressMinSize {
+		return false
+	}
+
+	setEncoding(h, c.encoding)
+	c.encoder = newEncoder(c.encoding, &c.buffer)
+	if contentLength > compressMaxBuffered {
+		h.Delete("Content-Length")
+		h.Overwrite("Transfer-Encoding", "chunked")
+		c.streamed = true
+		return false
+	}
+
+	c.pending = h
+	c.remaining = contentLength
+	return true
+}
+
+func (w *Writer) writeCompressedBody(data []byte) (int, error) {
+	c := w.compression
+	n, err := c.encoder.Write(data)
+	if err != nil {
+		return n, err
+	}
+
+	c.remaining -= n
+	if c.remaining <= 0 {
+		return n, w.finishCompressedBody()
+	}
+	return n, nil
+}
+
+func (w *Writer) writeCompressedChunk(data []byte) error {
+	c := w.compression
+	if len(data) > 0 {
+		_, err := c.encoder.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.buffer.Len() == 0 {
+		return nil
+	}
+
+	_, err := w.writeChunk(c.buffer.Bytes())
+	c.buffer.Reset()
+	return err
+}
+
+// setEncoding marks h as compressed. A strong ETag describes the identity bytes, which ranges are
+// served from, so it is weakened to keep If-Range from splicing identity bytes into a compressed body
+func setEncoding(h headers.Headers, encoding string) {
+	h.Overwrite("Content-Encoding", encoding)
+	weakenETag(h)
+}
+
+// describeEncoding gives h the Vary header and ETag that the full response for a representation of
+// contentType and size gets, for responses that describe it without carrying it such as a 304
+func (w *Writer) describeEncoding(h headers.Headers, contentType string, size int64) {
+	if w.compression == nil || !isCompressible(contentType) {
+		return
+	}
+
+	addVary(h)
+	if w.compression.encoding != "" && size >= compressMinSize {
+		weakenETag(h)
+	}
+}
+
+func weakenETag(h headers.Headers) {
+	if etag, exists := h.Get("ETag"); exists && !strings.HasPrefix(etag, "W/") {
+		h.Overwrite("ETag", "W/"+etag)
+	}
+}
+
+// addVary adds Accept-Encoding to Vary unless it is already listed
+func addVary(h headers.Headers) {
+	vary, _ := h.Get("Vary")
+	for _, token := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "Accept-Encoding") {
+			return
+		}
+	}
+	h.Set("Vary", "Accept-Encoding")
+}
+
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	if contentType == "image/svg+xml" {
+		return true
+	}
+
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiateEncoding prefers gzip over deflate when the client rates them equally. Without the header
+// the body is sent as is
+func negotiateEncoding(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	return request.PreferredEncoding(acceptEncoding, "gzip", "deflate")
+}
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == "deflate" {
+		return zlib.NewWriter(w)
+	}
+	return gzip.NewWriter(w)
+}
@@ -0,0 +1,65 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+)
+
+// CheckPreconditions answers req with 304 or 412 when its conditional headers rule out sending the
+// representation, and reports whether a response was written
+func (w *Writer) CheckPreconditions(req *request.Request, etag string, lastModified time.Time) (bool, error) {
+	return w.checkPreconditions(req, etag, lastModified, "", 0)
+}
+
+// checkPreconditions is CheckPreconditions for a representation of known type and size, whose 304
+// carries the Vary and ETag the full response would have been sent with
+func (w *Writer) checkPreconditions(req *request.Request, etag string, lastModified time.Time, contentType string, size int64) (bool, error) {
+	switch req.EvaluatePreconditions(etag, lastModified) {
+	case request.PreconditionNotModified:
+		err := w.WriteStatusLine(StatusCodeNotModified)
+		if err != nil {
+			return true, err
+		}
+
+		h := headers.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Delete("Content-Type")
+		setValidators(h, etag, lastModified)
+		if contentType != "" {
+			w.describeEncoding(h, contentType, size)
+		}
+		return true, w.WriteHeaders(h)
+	case request.PreconditionFailed:
+		return true, w.writeEmpty(StatusCodePreconditionFailed, headers.GetDefaultHeaders(0))
+	default:
+		return false, nil
+	}
+}
+
+func ETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+func FileETag(size int64, modTime time.Time) string {
+	return fmt.Sprintf("\"%x-%x\"", modTime.Unix(), size)
+}
+
+func WeakETag(data []byte) string {
+	return "W/" + ETag(data)
+}
+
+func setValidators(h headers.Headers, etag string, lastModified time.Time) {
+	if etag != "" {
+		h.Overwrite("ETag", etag)
+	}
+
+	if !lastModified.IsZero() {
+		h.Overwrite("Last-Modified", lastModified.UTC().Format(headers.TimeFormat))
+	}
+}
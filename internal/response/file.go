@@ -0,0 +1,236 @@
+package response
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+)
+
+// ServeContent writes content as the full response, honoring conditional headers, Range and If-Range
+// from req
+func (w *Writer) ServeContent(req *request.Request, content io.ReadSeeker, size int64, modTime time.Time, contentType string) error {
+	etag := ""
+	if !modTime.IsZero() {
+		etag = FileETag(size, modTime)
+	}
+
+	handled, err := w.checkPreconditions(req, etag, modTime, contentType, size)
+	if handled || err != nil {
+		return err
+	}
+
+	h := headers.GetDefaultHeaders(0)
+	h.Overwrite("Content-Type", contentType)
+	h.Overwrite("Accept-Ranges", "bytes")
+	setValidators(h, etag, modTime)
+	if w.compression != nil && isCompressible(contentType) {
+		// Ranges are always identity encoded, but the coding of the full response still varies
+		addVary(h)
+	}
+
+	ranges, err := requestedRanges(req, size, etag, modTime)
+	if errors.Is(err, request.ErrRangeNotSatisfiable) {
+		h.Overwrite("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return w.writeEmpty(StatusCodeRangeNotSatisfiable, h)
+	}
+
+	switch len(ranges) {
+	case 0:
+		return w.serveFull(content, size, h)
+	case 1:
+		return w.serveRange(content, size, ranges[0], h)
+	default:
+		return w.serveMultipartRanges(content, size, contentType, ranges, h)
+	}
+}
+
+func (w *Writer) ServeFile(req *request.Request, f *os.File, contentType string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return errors.New("cannot serve a directory")
+	}
+
+	return w.ServeContent(req, f, info.Size(), info.ModTime(), contentType)
+}
+
+// WriteFile streams f as the response body. Identity encoded bodies are copied straight onto the
+// underlying connection, which lets the kernel use sendfile/splice when it is a *net.TCPConn
+func (w *Writer) WriteFile(f *os.File) (int64, error) {
+	if w.status != WriteStatusBody {
+		return 0, errors.New("cannot write body yet (or has already been written)")
+	}
+
+	if !w.isIdentity() {
+		return io.Copy(w, f)
+	}
+
+	err := w.writer.Flush()
+	if err != nil {
+		return 0, err
+	}
+	w.sent = true
+	return io.Copy(w.conn, f)
+}
+
+func (w *Writer) isIdentity() bool {
+	if w.chunked {
+		return false
+	}
+
+	if w.compression != nil && w.compression.pending != nil {
+		return false
+	}
+
+	if w.framing != nil && w.framing.pending != nil {
+		return false
+	}
+	return true
+}
+
+func (w *Writer) serveFull(content io.ReadSeeker, size int64, h headers.Headers) error {
+	err := w.WriteStatusLine(StatusCodeOK)
+	if err != nil {
+		return err
+	}
+
+	h.Overwrite("Content-Length", strconv.FormatInt(size, 10))
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writeSection(content, 0, size)
+	return err
+}
+
+func (w *Writer) serveMultipartRanges(content io.ReadSeeker, size int64, contentType string, ranges []request.Range, h headers.Headers) error {
+	boundary, err := randomBoundary()
+	if err != nil {
+		return err
+	}
+
+	parts := make([]string, len(ranges))
+	var length int64
+	for i, r := range ranges {
+		delimiter := "--" + boundary
+		if i > 0 {
+			delimiter = "\r\n" + delimiter
+		}
+		parts[i] = fmt.Sprintf("%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", delimiter, contentType, r.ContentRange(size))
+		length += int64(len(parts[i])) + r.Length
+	}
+	closing := "\r\n--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	err = w.WriteStatusLine(StatusCodePartialContent)
+	if err != nil {
+		return err
+	}
+
+	h.Overwrite("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Overwrite("Content-Length", strconv.FormatInt(length, 10))
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	for i, r := range ranges {
+		_, err = w.WriteBody([]byte(parts[i]))
+		if err != nil {
+			return err
+		}
+
+		_, err = w.writeSection(content, r.Start, r.Length)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.WriteBody([]byte(closing))
+	return err
+}
+
+func (w *Writer) serveRange(content io.ReadSeeker, size int64, r request.Range, h headers.Headers) error {
+	err := w.WriteStatusLine(StatusCodePartialContent)
+	if err != nil {
+		return err
+	}
+
+	h.Overwrite("Content-Range", r.ContentRange(size))
+	h.Overwrite("Content-Length", strconv.FormatInt(r.Length, 10))
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writeSection(content, r.Start, r.Length)
+	return err
+}
+
+func (w *Writer) writeEmpty(code StatusCode, h headers.Headers) error {
+	err := w.WriteStatusLine(code)
+	if err != nil {
+		return err
+	}
+
+	h.Overwrite("Content-Length", "0")
+	return w.WriteHeaders(h)
+}
+
+func (w *Writer) writeSection(content io.ReadSeeker, offset, length int64) (int64, error) {
+	_, err := content.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	// A LimitedReader around an *os.File keeps the sendfile fast path available
+	section := &io.LimitedReader{R: content, N: length}
+	if !w.isIdentity() {
+		return io.Copy(w, section)
+	}
+
+	err = w.writer.Flush()
+	if err != nil {
+		return 0, err
+	}
+	w.sent = true
+	return io.Copy(w.conn, section)
+}
+
+func randomBoundary() (string, error) {
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+func requestedRanges(req *request.Request, size int64, etag string, modTime time.Time) ([]request.Range, error) {
+	if req.RequestLine.Method != "GET" {
+		return nil, nil
+	}
+
+	header, exists := req.Headers.Get("Range")
+	if !exists {
+		return nil, nil
+	}
+
+	if !req.IfRange(etag, modTime) {
+		return nil, nil
+	}
+
+	return request.ParseRange(header, size)
+}
@@ -0,0 +1,138 @@
+package response
+
+import (
+	"bytes"
+	"maps"
+	"strconv"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+)
+
+const DefaultFrameThreshold = 32 * 1024
+
+type framing struct {
+	threshold int
+	buffer    bytes.Buffer
+	pending   headers.Headers
+	trailers  headers.Headers
+}
+
+func (w *Writer) EnableAutoFraming(threshold int) {
+	w.framing = &framing{
+		threshold: threshold,
+		trailers:  headers.NewHeaders(),
+	}
+}
+
+func (w *Writer) SetTrailer(key, val string) {
+	if w.framing == nil {
+		return
+	}
+	w.framing.trailers.Set(key, val)
+}
+
+// commitChunked switches a buffered response to chunked encoding and writes out everything held so far
+func (w *Writer) commitChunked() error {
+	f := w.framing
+	h := f.pending
+	f.pending = nil
+	h.Delete("Content-Length")
+	h.Overwrite("Transfer-Encoding", "chunked")
+
+	if w.compression != nil {
+		w.prepareCompression(h)
+	}
+
+	err := w.writeHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	data := f.buffer.Bytes()
+	f.buffer = bytes.Buffer{}
+	_, err = w.WriteChunkedBody(data)
+	return err
+}
+
+// commitFixed sends a buffered response with a Content-Length computed from the buffered body
+func (w *Writer) commitFixed() error {
+	f := w.framing
+	h := f.pending
+	f.pending = nil
+	h.Delete("Transfer-Encoding")
+	h.Overwrite("Content-Length", strconv.Itoa(f.buffer.Len()))
+
+	if w.compression != nil && w.prepareCompression(h) {
+		_, err := w.writeCompressedBody(f.buffer.Bytes())
+		return err
+	}
+
+	err := w.writeHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writer.Write(f.buffer.Bytes())
+	return err
+}
+
+func (w *Writer) finishFraming() error {
+	f := w.framing
+
+	if w.status == WriteStatusHeaders {
+		err := w.WriteHeaders(headers.NewHeaders())
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.pending != nil {
+		_, hasTrailer := f.pending.Get("Trailer")
+		if len(f.trailers) == 0 && !hasTrailer {
+			return w.commitFixed()
+		}
+
+		err := w.commitChunked()
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.status == WriteStatusBody && w.chunked {
+		_, err := w.WriteChunkedBodyDone()
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.status == WriteStatusTrailers {
+		return w.WriteTrailers(f.trailers)
+	}
+	return nil
+}
+
+// holdHeaders defers h until the body size is known, unless the handler already chose its own framing
+func (w *Writer) holdHeaders(h headers.Headers) bool {
+	if !BodyAllowed(w.code) {
+		return false
+	}
+
+	_, hasLength := h.Get("Content-Length")
+	_, hasEncoding := h.Get("Transfer-Encoding")
+	if hasLength || hasEncoding {
+		return false
+	}
+
+	w.framing.pending = maps.Clone(h)
+	return true
+}
+
+func (w *Writer) writeFramed(data []byte) (int, error) {
+	f := w.framing
+	f.buffer.Write(data)
+	if f.buffer.Len() <= f.threshold {
+		return len(data), nil
+	}
+
+	return len(data), w.commitChunked()
+}
@@ -0,0 +1,57 @@
+package response
+
+import (
+	"encoding/json"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+)
+
+// Problem is an RFC 9457 problem details object. An empty Type means "about:blank"
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func WriteJSON(w *Writer, code StatusCode, v any) error {
+	h := headers.NewHeaders()
+	h.Overwrite("Content-Type", "application/json")
+	return writeJSON(w, code, h, v)
+}
+
+// WriteProblem sends problem with its Status as the status code, or 500 when it has none
+func WriteProblem(w *Writer, problem Problem) error {
+	if problem.Status == 0 {
+		problem.Status = StatusCodeInternalServerError
+	}
+	if problem.Title == "" {
+		problem.Title = StatusText(StatusCode(problem.Status))
+	}
+
+	h := headers.NewHeaders()
+	h.Overwrite("Content-Type", "application/problem+json")
+	return writeJSON(w, StatusCode(problem.Status), h, problem)
+}
+
+func writeJSON(w *Writer, code StatusCode, h headers.Headers, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	err = w.WriteStatusLine(code)
+	if err != nil {
+		return err
+	}
+
+	h = mergeHeaders(headers.GetDefaultHeaders(len(body)), h)
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.WriteBody(body)
+	return err
+}
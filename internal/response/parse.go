@@ -0,0 +1,391 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+)
+
+type parseStatus int
+
+const (
+	responseStatusInitialized = iota
+	responseStatusParsingHeaders
+	responseStatusParsingBody
+	responseStatusParsingChunkSize
+	responseStatusParsingChunkData
+	responseStatusParsingChunkEnd
+	responseStatusParsingTrailers
+	responseStatusDone
+)
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	Trailers   headers.Headers
+	status     parseStatus
+	method     string
+	bodyLength int
+	bodyRead   int
+	chunkLeft  int
+	buffered   []byte
+	source     *bufio.Reader
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+func FromReader(reader io.Reader) (*Response, error) {
+	return FromReaderForMethod(reader, "")
+}
+
+// FromReaderForMethod parses a response to a request made with method, which decides whether a body
+// follows the headers, as for HEAD
+func FromReaderForMethod(reader io.Reader, method string) (*Response, error) {
+	response := &Response{
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+		status:   responseStatusInitialized,
+		method:   method,
+	}
+	buffer := make([]byte, 8)
+	bytesRead := 0
+
+	for response.status != responseStatusDone {
+		if bytesRead >= len(buffer) {
+			newBuffer := make([]byte, len(buffer)*2)
+			copy(newBuffer, buffer)
+			buffer = newBuffer
+		}
+
+		readCount, err := reader.Read(buffer[bytesRead:])
+
+		if err != nil {
+			if err == io.EOF {
+				// Without Content-Length or chunked framing the body runs until the connection closes
+				if response.status == responseStatusParsingBody && response.bodyLength < 0 {
+					response.status = responseStatusDone
+					break
+				}
+				return nil, fmt.Errorf("error reading response: %w", io.ErrUnexpectedEOF)
+			}
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+
+		bytesRead += readCount
+
+		parsedCount, err := response.parse(buffer[:bytesRead])
+		if err != nil {
+			return nil, err
+		}
+
+		if parsedCount != 0 {
+			copy(buffer, buffer[parsedCount:])
+			bytesRead -= parsedCount
+		}
+	}
+
+	if bytesRead > 0 {
+		response.buffered = slices.Clone(buffer[:bytesRead])
+	}
+	return response, nil
+}
+
+// ReadHead reads a response to a request made with method up to the end of its headers, skipping
+// interim responses. The body is left in reader for BodyReader to stream, so a persistent connection
+// can be read response after response
+func ReadHead(reader *bufio.Reader, method string) (*Response, error) {
+	response := &Response{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		status:   responseStatusInitialized,
+		method:   method,
+		source:   reader,
+	}
+
+	for response.status == responseStatusInitialized || response.status == responseStatusParsingHeaders {
+		_, err := response.step(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// BodyReader streams the body of a response read by ReadHead, filling in Trailers once it ends. It
+// returns nil when there is no body, and delimited reports whether the body ends before the
+// connection does
+func (r *Response) BodyReader() (body io.Reader, delimited bool) {
+	if r.status == responseStatusDone {
+		return nil, true
+	}
+	return &bodyReader{r}, r.status != responseStatusParsingBody || r.bodyLength >= 0
+}
+
+type bodyReader struct {
+	response *Response
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for b.response.status != responseStatusDone {
+		n, err := b.response.step(p)
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, io.EOF
+}
+
+// step parses whatever the source has buffered, reading more when that is not enough to make
+// progress. Body bytes are copied into p, and the number copied is returned
+func (r *Response) step(p []byte) (int, error) {
+	want := max(r.source.Buffered(), 1)
+	for {
+		data, err := r.source.Peek(want)
+		if err == bufio.ErrBufferFull {
+			return 0, errors.New("response line too long")
+		}
+		if err != nil {
+			if err != io.EOF || len(data) != 0 {
+				return 0, fmt.Errorf("error reading response: %w", unexpected(err))
+			}
+			// Without Content-Length or chunked framing the body runs until the connection closes
+			if r.status == responseStatusParsingBody && r.bodyLength < 0 {
+				r.status = responseStatusDone
+				return 0, nil
+			}
+			if r.status == responseStatusInitialized && r.source.Buffered() == 0 && want == 1 {
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("error reading response: %w", io.ErrUnexpectedEOF)
+		}
+
+		if r.status == responseStatusParsingBody || r.status == responseStatusParsingChunkData {
+			data = data[:min(len(data), len(p))]
+		}
+
+		r.Body = p[:0]
+		n, err := r.parseSingle(data)
+		copied := len(r.Body)
+		r.Body = nil
+		_, _ = r.source.Discard(n)
+		if n > 0 || err != nil {
+			return copied, err
+		}
+		want = len(data) + 1
+	}
+}
+
+// Buffered returns bytes read past the end of the response, such as the start of the next one on a
+// persistent connection
+func (r *Response) Buffered() []byte {
+	return r.buffered
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalParsedBytes := 0
+
+	for r.status != responseStatusDone {
+		n, err := r.parseSingle(data[totalParsedBytes:])
+		totalParsedBytes += n
+
+		if n == 0 || err != nil {
+			return totalParsedBytes, err
+		}
+	}
+
+	return totalParsedBytes, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.status {
+	case responseStatusInitialized:
+		n, statusLine, err := parseStatusLine(data)
+
+		if n == 0 || err != nil {
+			return n, err
+		}
+
+		r.StatusLine = *statusLine
+		r.status = responseStatusParsingHeaders
+		return n, nil
+	case responseStatusParsingHeaders:
+		n, done, err := r.Headers.Parse(data)
+
+		if done {
+			n += 2
+			err = r.startBody()
+		}
+
+		return n, err
+	case responseStatusParsingBody:
+		if r.bodyLength < 0 {
+			r.Body = append(r.Body, data...)
+			return len(data), nil
+		}
+
+		n := min(len(data), r.bodyLength-r.bodyRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyRead += n
+
+		if r.bodyRead == r.bodyLength {
+			r.status = responseStatusDone
+		}
+		return n, nil
+	case responseStatusParsingChunkSize:
+		idx := strings.Index(string(data), "\r\n")
+		if idx == -1 {
+			return 0, nil
+		}
+
+		sizeStr, _, _ := strings.Cut(string(data[:idx]), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 32)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk size: %q", data[:idx])
+		}
+
+		r.chunkLeft = int(size)
+		r.status = responseStatusParsingChunkData
+		if size == 0 {
+			r.status = responseStatusParsingTrailers
+		}
+		return idx + 2, nil
+	case responseStatusParsingChunkData:
+		n := min(len(data), r.chunkLeft)
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkLeft -= n
+
+		if r.chunkLeft == 0 {
+			r.status = responseStatusParsingChunkEnd
+		}
+		return n, nil
+	case responseStatusParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+
+		if string(data[:2]) != "\r\n" {
+			return 0, errors.New("missing CRLF after chunk")
+		}
+
+		r.status = responseStatusParsingChunkSize
+		return 2, nil
+	case responseStatusParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+
+		if done {
+			r.status = responseStatusDone
+			n += 2
+		}
+
+		return n, err
+	case responseStatusDone:
+		return 0, errors.New("response already parsed")
+	default:
+		return 0, fmt.Errorf("unknown state: %d", r.status)
+	}
+}
+
+// startBody picks the body framing once the headers are in. Interim 1xx responses other than 101 are
+// dropped and parsing starts over with the final response
+func (r *Response) startBody() error {
+	code := r.StatusLine.StatusCode
+
+	if code >= 100 && code < 200 && code != StatusCodeSwitchingProtocols {
+		r.Headers = headers.NewHeaders()
+		r.status = responseStatusInitialized
+		return nil
+	}
+
+	if r.method == "HEAD" || !BodyAllowed(code) {
+		r.status = responseStatusDone
+		return nil
+	}
+
+	if transferEncoding, exists := r.Headers.Get("Transfer-Encoding"); exists {
+		codings := strings.Split(transferEncoding, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.status = responseStatusParsingChunkSize
+			return nil
+		}
+
+		r.bodyLength = -1
+		r.status = responseStatusParsingBody
+		return nil
+	}
+
+	contentLengthHeader, exists := r.Headers.Get("Content-Length")
+	if !exists {
+		r.bodyLength = -1
+		r.status = responseStatusParsingBody
+		return nil
+	}
+
+	contentLength, err := strconv.Atoi(contentLengthHeader)
+	if err != nil || contentLength < 0 {
+		return fmt.Errorf("invalid content length: %q", contentLengthHeader)
+	}
+
+	r.bodyLength = contentLength
+	r.status = responseStatusParsingBody
+	if contentLength == 0 {
+		r.status = responseStatusDone
+	}
+	return nil
+}
+
+func parseStatusLine(data []byte) (int, *StatusLine, error) {
+	str := string(data)
+
+	if !strings.Contains(str, "\r\n") {
+		return 0, nil, nil
+	}
+
+	line := strings.Split(str, "\r\n")[0]
+	parts := strings.SplitN(line, " ", 3)
+
+	if len(parts) < 2 {
+		return 0, nil, errors.New("invalid status line")
+	}
+
+	if parts[0] != "HTTP/1.1" && parts[0] != "HTTP/1.0" {
+		return 0, nil, fmt.Errorf("unsupported version: %s", parts[0])
+	}
+
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 {
+		return 0, nil, fmt.Errorf("invalid status code: %s", parts[1])
+	}
+
+	statusLine := &StatusLine{
+		HttpVersion: strings.TrimPrefix(parts[0], "HTTP/"),
+		StatusCode:  StatusCode(code),
+	}
+	if len(parts) == 3 {
+		statusLine.ReasonPhrase = parts[2]
+	}
+
+	return len(line) + 2, statusLine, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
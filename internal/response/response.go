@@ -1,22 +1,66 @@
 package response
 
 import (
-	"bytes"
+	"bufio"
 	"errors"
 	"fmt"
+	"html"
 	"io"
+	"maps"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
 )
 
 type StatusCode int
 
 const (
-	StatusCodeOK                  = 200
-	StatusCodeBadRequest          = 400
-	StatusCodeInternalServerError = 500
+	StatusCodeSwitchingProtocols    = 101
+	StatusCodeOK                    = 200
+	StatusCodeNoContent             = 204
+	StatusCodePartialContent        = 206
+	StatusCodeMovedPermanently      = 301
+	StatusCodeNotModified           = 304
+	StatusCodeBadRequest            = 400
+	StatusCodeForbidden             = 403
+	StatusCodeNotFound              = 404
+	StatusCodeMethodNotAllowed      = 405
+	StatusCodeNotAcceptable         = 406
+	StatusCodePreconditionFailed    = 412
+	StatusCodeRequestEntityTooLarge = 413
+	StatusCodeUnsupportedMediaType  = 415
+	StatusCodeRangeNotSatisfiable   = 416
+	StatusCodeUpgradeRequired       = 426
+	StatusCodeInternalServerError   = 500
+	StatusCodeBadGateway            = 502
+	StatusCodeGatewayTimeout        = 504
 )
 
+var statusText = map[StatusCode]string{
+	StatusCodeSwitchingProtocols:    "Switching Protocols",
+	StatusCodeOK:                    "OK",
+	StatusCodeNoContent:             "No Content",
+	StatusCodePartialContent:        "Partial Content",
+	StatusCodeMovedPermanently:      "Moved Permanently",
+	StatusCodeNotModified:           "Not Modified",
+	StatusCodeBadRequest:            "Bad Request",
+	StatusCodeForbidden:             "Forbidden",
+	StatusCodeNotFound:              "Not Found",
+	StatusCodeMethodNotAllowed:      "Method Not Allowed",
+	StatusCodeNotAcceptable:         "Not Acceptable",
+	StatusCodePreconditionFailed:    "Precondition Failed",
+	StatusCodeRequestEntityTooLarge: "Content Too Large",
+	StatusCodeUnsupportedMediaType:  "Unsupported Media Type",
+	StatusCodeRangeNotSatisfiable:   "Range Not Satisfiable",
+	StatusCodeUpgradeRequired:       "Upgrade Required",
+	StatusCodeInternalServerError:   "Internal Server Error",
+	StatusCodeBadGateway:            "Bad Gateway",
+	StatusCodeGatewayTimeout:        "Gateway Timeout",
+}
+
 type WriteStatus int
 
 const (
@@ -24,6 +68,7 @@ const (
 	WriteStatusHeaders
 	WriteStatusBody
 	WriteStatusTrailers
+	WriteStatusDone
 )
 
 const (
@@ -44,19 +89,223 @@ const (
     <h1>Internal Server Error</h1>
     <p>Okay, you know what? This one is on me.</p>
   </body>
+</html>`
+	bodyGenericError = `<html>
+  <head>
+    <title>%d %s</title>
+  </head>
+  <body>
+    <h1>%s</h1>%s
+  </body>
 </html>`
 )
 
 type Writer struct {
-	status WriteStatus
-	writer io.Writer
+	status      WriteStatus
+	code        StatusCode
+	conn        io.Writer
+	writer      *bufio.Writer
+	sent        bool
+	chunked     bool
+	hijacked    bool
+	buffered    []byte
+	compression *compression
+	framing     *framing
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{
-		writer: w,
+	writer := &Writer{
+		conn:   w,
 		status: WriteStatusLine,
 	}
+	writer.writer = bufio.NewWriter(sentWriter{writer})
+	return writer
+}
+
+// sentWriter sits between the buffer and the connection and records that bytes reached the client
+type sentWriter struct {
+	w *Writer
+}
+
+func (s sentWriter) Write(data []byte) (int, error) {
+	s.w.sent = true
+	return s.w.conn.Write(data)
+}
+
+// Abort drops any buffered output and refuses further writes. The caller is expected to close the
+// connection, since the client has already seen part of a response
+func (w *Writer) Abort() {
+	w.writer.Reset(sentWriter{w})
+	w.status = WriteStatusDone
+}
+
+// Committed reports whether any of the response has reached the connection, after which no other
+// response can be sent
+func (w *Writer) Committed() bool {
+	return w.sent
+}
+
+// Reset discards a response that is still only buffered, so another can be written in its place.
+// Compression and auto framing stay enabled
+func (w *Writer) Reset() error {
+	if w.sent {
+		return errors.New("response already sent")
+	}
+	if w.hijacked {
+		return errors.New("connection hijacked")
+	}
+
+	w.writer.Reset(sentWriter{w})
+	w.status = WriteStatusLine
+	w.code = 0
+	w.chunked = false
+	if w.compression != nil {
+		w.compression = &compression{encoding: w.compression.encoding}
+	}
+	if w.framing != nil {
+		w.EnableAutoFraming(w.framing.threshold)
+	}
+	return nil
+}
+
+// EndChunkedWithError terminates an in-progress chunked body early and reports the failure in an
+// X-Error trailer field, so the client can tell the body is incomplete without losing the connection
+func (w *Writer) EndChunkedWithError(code StatusCode, message string) error {
+	if !w.chunked || (w.status != WriteStatusBody && w.status != WriteStatusTrailers) {
+		return errors.New("response is not an in-progress chunked body")
+	}
+
+	if w.status == WriteStatusBody {
+		_, err := w.WriteChunkedBodyDone()
+		if err != nil {
+			return err
+		}
+	}
+
+	val := fmt.Sprintf("%d %s", code, StatusText(code))
+	if message != "" {
+		val += ": " + strings.NewReplacer("\r", " ", "\n", " ").Replace(message)
+	}
+
+	trailers := headers.NewHeaders()
+	if w.framing != nil {
+		trailers = w.framing.trailers
+	}
+	trailers.Overwrite("X-Error", val)
+	return w.WriteTrailers(trailers)
+}
+
+// Finish completes whatever the handler left open. A compressed body still held back, such as one
+// shorter than its declared Content-Length, is sent before framing is finished, and a compressed body
+// streamed in place of its declared length gets its last chunk
+func (w *Writer) Finish() error {
+	if w.compression != nil && w.compression.pending != nil {
+		err := w.finishCompressedBody()
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.framing != nil {
+		return w.finishFraming()
+	}
+
+	// A declared length too large to buffer was turned into a chunked body the handler cannot end
+	if w.compression != nil && w.compression.streamed && w.status == WriteStatusBody {
+		_, err := w.WriteChunkedBodyDone()
+		if err != nil {
+			return err
+		}
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+	return nil
+}
+
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return nil
+	}
+	return w.writer.Flush()
+}
+
+// Hijack flushes anything written so far and hands the connection to the caller, who becomes
+// responsible for closing it, along with any bytes the server already read past the request. The
+// Writer cannot be used afterwards
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, errors.New("connection already hijacked")
+	}
+
+	conn, ok := w.conn.(net.Conn)
+	if !ok {
+		return nil, nil, errors.New("underlying writer is not a connection")
+	}
+
+	err := w.writer.Flush()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.hijacked = true
+	w.status = WriteStatusDone
+	buffered := w.buffered
+	w.buffered = nil
+	return conn, buffered, nil
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// SetBuffered records bytes read from the connection past the current request, so Hijack can hand
+// them over
+func (w *Writer) SetBuffered(data []byte) {
+	w.buffered = data
+}
+
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.status != WriteStatusBody {
+		return 0, errors.New("cannot write body yet (or has already been written)")
+	}
+
+	var total int64
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			_, wErr := w.Write(buffer[:n])
+			total += int64(n)
+			if wErr != nil {
+				return total, wErr
+			}
+		}
+
+		if err == io.EOF {
+			return total, nil
+		}
+
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	if !w.chunked {
+		return w.WriteBody(data)
+	}
+
+	_, err := w.WriteChunkedBody(data)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *Writer) Redirect(location string, code StatusCode) error {
+	h := headers.GetDefaultHeaders(0)
+	h.Overwrite("Location", location)
+	return w.writeEmpty(code, h)
 }
 
 func (w *Writer) WriteBody(data []byte) (int, error) {
@@ -64,6 +313,19 @@ func (w *Writer) WriteBody(data []byte) (int, error) {
 		return 0, errors.New("cannot write body yet (or has already been written)")
 	}
 
+	if w.framing != nil && w.framing.pending != nil {
+		return w.writeFramed(data)
+	}
+
+	// A chunked body, such as one auto framing switched to, needs every write framed as a chunk
+	if w.chunked {
+		return w.Write(data)
+	}
+
+	if w.compression != nil && w.compression.pending != nil {
+		return w.writeCompressedBody(data)
+	}
+
 	_, err := w.writer.Write(data)
 	if err != nil {
 		return 0, err
@@ -81,13 +343,15 @@ func (w *Writer) WriteChunkedBody(data []byte) (int, error) {
 		return 0, nil
 	}
 
-	var buffer bytes.Buffer
-	buffer.WriteString(fmt.Sprintf("%x\r\n", len(data)))
-	buffer.Write(data)
-	buffer.WriteString("\r\n")
+	if w.framing != nil && w.framing.pending != nil {
+		return w.writeFramed(data)
+	}
+
+	if w.compression != nil && w.compression.encoder != nil {
+		return len(data), w.writeCompressedChunk(data)
+	}
 
-	n, err := w.writer.Write(buffer.Bytes())
-	return n, err
+	return w.writeChunk(data)
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
@@ -95,52 +359,81 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 		return 0, errors.New("cannot write body yet (or has already been written)")
 	}
 
+	if w.framing != nil && w.framing.pending != nil {
+		err := w.commitChunked()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if w.compression != nil && w.compression.encoder != nil {
+		err := w.finishCompressedChunks()
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	body := []byte("0\r\n")
 	w.status = WriteStatusTrailers
 	return w.writer.Write(body)
 }
 
 func (w *Writer) WriteError(code StatusCode) error {
-	body := bodyBadRequest
-	if code == StatusCodeInternalServerError {
-		body = bodyInternalServerError
-	}
-
-	errorHeaders := headers.GetDefaultHeaders(len(body))
-	errorHeaders.Overwrite("Content-Type", "text/html")
-
-	err := w.WriteStatusLine(code)
-	if err != nil {
-		return fmt.Errorf("error writing status line: %w", err)
-	}
+	return w.writeError(code, "text/html", htmlErrorBody(code, ""), nil)
+}
 
-	err = w.WriteHeaders(errorHeaders)
-	if err != nil {
-		return fmt.Errorf("error writing headers: %w", err)
+// WriteErrorDetail writes an error page in whichever format req prefers, carrying message as the
+// human readable detail and any extra response headers
+func (w *Writer) WriteErrorDetail(req *request.Request, code StatusCode, message string, extra headers.Headers) error {
+	switch req.Negotiate("text/html", "application/problem+json", "application/json", "text/plain") {
+	case "application/problem+json", "application/json":
+		h := mergeHeaders(headers.NewHeaders(), extra)
+		h.Overwrite("Content-Type", "application/problem+json")
+		return writeJSON(w, code, h, Problem{Title: StatusText(code), Status: int(code), Detail: message})
+	case "text/plain":
+		body := fmt.Sprintf("%d %s\n", code, StatusText(code))
+		if message != "" {
+			body += message + "\n"
+		}
+		return w.writeError(code, "text/plain", body, extra)
+	default:
+		return w.writeError(code, "text/html", htmlErrorBody(code, message), extra)
 	}
+}
 
-	_, err = w.WriteBody([]byte(body))
-	if err != nil {
-		return fmt.Errorf("error writing response body: %w", err)
+// WriteErrorBody writes an error response with a body of the caller's choosing. h must carry its
+// Content-Type
+func (w *Writer) WriteErrorBody(code StatusCode, body []byte, h headers.Headers) error {
+	contentType, exists := h.Get("Content-Type")
+	if !exists {
+		return errors.New("error body without a Content-Type")
 	}
+	return w.writeError(code, contentType, string(body), h)
+}
 
-	return nil
+func (w *Writer) WriteErrorFor(req *request.Request, code StatusCode) error {
+	return w.WriteErrorDetail(req, code, "", nil)
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.status != WriteStatusHeaders {
 		return errors.New("cannot write headers yet (or has already been written)")
 	}
 
-	for key, val := range headers {
-		_, err := w.writer.Write([]byte(key + ": " + val + "\r\n"))
-		if err != nil {
-			return err
+	if w.framing != nil && w.holdHeaders(h) {
+		w.status = WriteStatusBody
+		return nil
+	}
+
+	if w.compression != nil {
+		h = maps.Clone(h)
+		if w.prepareCompression(h) {
+			w.status = WriteStatusBody
+			return nil
 		}
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
-	w.status = WriteStatusBody
-	return err
+
+	return w.writeHeaders(h)
 }
 
 func (w *Writer) WriteStatusLine(code StatusCode) error {
@@ -148,19 +441,9 @@ func (w *Writer) WriteStatusLine(code StatusCode) error {
 		return errors.New("cannot write status line twice")
 	}
 
-	str := ""
-	switch code {
-	case StatusCodeOK:
-		str = "HTTP/1.1 200 OK"
-	case StatusCodeBadRequest:
-		str = "HTTP/1.1 400 Bad Request"
-	case StatusCodeInternalServerError:
-		str = "HTTP/1.1 500 Internal Server Error"
-	default:
-		str = fmt.Sprintf("HTTP/1.1 %d ", code)
-	}
-
+	str := fmt.Sprintf("HTTP/1.1 %d %s", code, StatusText(code))
 	_, err := w.writer.Write([]byte(str + "\r\n"))
+	w.code = code
 	w.status = WriteStatusHeaders
 	return err
 }
@@ -177,5 +460,91 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		}
 	}
 	_, err := w.writer.Write([]byte("\r\n"))
+	w.status = WriteStatusDone
 	return err
 }
+
+func (w *Writer) writeChunk(data []byte) (int, error) {
+	n, err := fmt.Fprintf(w.writer, "%x\r\n", len(data))
+	if err != nil {
+		return n, err
+	}
+
+	m, err := w.writer.Write(data)
+	n += m
+	if err != nil {
+		return n, err
+	}
+
+	m, err = w.writer.WriteString("\r\n")
+	return n + m, err
+}
+
+func (w *Writer) writeError(code StatusCode, contentType string, body string, extra headers.Headers) error {
+	errorHeaders := mergeHeaders(headers.GetDefaultHeaders(len(body)), extra)
+	errorHeaders.Overwrite("Content-Length", strconv.Itoa(len(body)))
+	errorHeaders.Overwrite("Content-Type", contentType)
+
+	err := w.WriteStatusLine(code)
+	if err != nil {
+		return fmt.Errorf("error writing status line: %w", err)
+	}
+
+	err = w.WriteHeaders(errorHeaders)
+	if err != nil {
+		return fmt.Errorf("error writing headers: %w", err)
+	}
+
+	_, err = w.WriteBody([]byte(body))
+	if err != nil {
+		return fmt.Errorf("error writing response body: %w", err)
+	}
+
+	return nil
+}
+
+func (w *Writer) writeHeaders(h headers.Headers) error {
+	for key, val := range h {
+		_, err := w.writer.Write([]byte(key + ": " + val + "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.writer.Write([]byte("\r\n"))
+	transferEncoding, _ := h.Get("Transfer-Encoding")
+	w.chunked = strings.EqualFold(transferEncoding, "chunked")
+	w.status = WriteStatusBody
+	return err
+}
+
+func htmlErrorBody(code StatusCode, message string) string {
+	if message == "" {
+		switch code {
+		case StatusCodeBadRequest:
+			return bodyBadRequest
+		case StatusCodeInternalServerError:
+			return bodyInternalServerError
+		}
+	}
+
+	paragraph := ""
+	if message != "" {
+		paragraph = "\n    <p>" + html.EscapeString(message) + "</p>"
+	}
+	return fmt.Sprintf(bodyGenericError, code, StatusText(code), StatusText(code), paragraph)
+}
+
+func mergeHeaders(h headers.Headers, extra headers.Headers) headers.Headers {
+	for key, val := range extra {
+		h.Overwrite(key, val)
+	}
+	return h
+}
+
+func BodyAllowed(code StatusCode) bool {
+	return code >= 200 && code != StatusCodeNoContent && code != StatusCodeNotModified
+}
+
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
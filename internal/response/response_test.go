@@ -0,0 +1,726 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestResponseParse(t *testing.T) {
+	// Test: Content-Length body
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCode(StatusCodeOK), r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", r.Headers["content-type"])
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Chunked body with extensions and trailers
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+			"5\r\nhello\r\n7;ext=1\r\n, world\r\n0\r\nX-Sum: 42\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = FromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, "42", r.Trailers["x-sum"])
+
+	// Test: Interim responses are skipped
+	reader = &chunkReader{
+		data:            "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 5,
+	}
+	r, err = FromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(201), r.StatusLine.StatusCode)
+	assert.Equal(t, "Created", r.StatusLine.ReasonPhrase)
+	assert.NotContains(t, r.Headers, "link")
+	assert.Equal(t, "ok", string(r.Body))
+
+	// Test: 204 and 304 have no body, whatever follows belongs to the next response
+	for _, status := range []string{"204 No Content", "304 Not Modified"} {
+		stream := strings.NewReader("HTTP/1.1 " + status + "\r\nContent-Length: 10\r\n\r\nHTTP/1.1 200 OK\r\n")
+		r, err = FromReader(stream)
+		require.NoError(t, err)
+		assert.Empty(t, r.Body)
+		rest, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(r.Buffered())+string(rest))
+	}
+
+	// Test: Responses to HEAD have no body
+	r, err = FromReaderForMethod(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n"), "HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: Body delimited by the connection closing
+	r, err = FromReader(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nuntil the end"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.Equal(t, "until the end", string(r.Body))
+
+	// Test: Pipelined bytes past Content-Length are kept
+	stream := strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabcHTTP/1.1")
+	r, err = FromReader(stream)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	rest, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1", string(r.Buffered())+string(rest))
+
+	// Test: Empty reason phrase
+	r, err = FromReader(strings.NewReader("HTTP/1.1 418 \r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(418), r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+
+	// Test: Truncated body
+	_, err = FromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Truncated chunked body
+	_, err = FromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Invalid status line
+	_, err = FromReader(strings.NewReader("HTTP/1.1 OK\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Invalid chunk size
+	_, err = FromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	require.Error(t, err)
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	// Test: Error page
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteError(StatusCodeNotFound))
+	require.NoError(t, w.Flush())
+
+	r, err := FromReader(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(StatusCodeNotFound), r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/html", r.Headers["content-type"])
+	assert.Contains(t, string(r.Body), "<h1>Not Found</h1>")
+
+	// Test: Chunked body with trailers
+	buffer.Reset()
+	w = NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Count")
+	require.NoError(t, w.WriteHeaders(h))
+	for _, part := range []string{"one ", "two ", "three"} {
+		_, err = w.Write([]byte(part))
+		require.NoError(t, err)
+	}
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Count", "3")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Flush())
+
+	r, err = FromReader(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "one two three", string(r.Body))
+	assert.Equal(t, "3", r.Trailers["x-count"])
+
+	// Test: Auto framing with compression switches to chunked once the body outgrows the threshold
+	buffer.Reset()
+	w = NewWriter(&buffer)
+	w.EnableCompression("gzip")
+	w.EnableAutoFraming(16)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	h = headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.Write([]byte(strings.Repeat("compressible ", 200)))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	require.NoError(t, w.Flush())
+
+	r, err = FromReader(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "chunked", r.Headers["transfer-encoding"])
+	assert.Equal(t, "gzip", r.Headers["content-encoding"])
+	assert.Empty(t, r.Buffered())
+
+	gz, err := gzip.NewReader(bytes.NewReader(r.Body))
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("compressible ", 200), string(body))
+}
+
+// record runs fn against a Writer and parses what it wrote
+func record(t *testing.T, fn func(w *Writer)) *Response {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	fn(w)
+	require.NoError(t, w.Finish())
+	require.NoError(t, w.Flush())
+
+	r, err := FromReader(&buffer)
+	require.NoError(t, err)
+	assert.Empty(t, r.Buffered())
+	return r
+}
+
+func writeText(t *testing.T, w *Writer, contentType, body string, chunked bool) {
+	t.Helper()
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	h := headers.NewHeaders()
+	h.Set("Content-Type", contentType)
+	if chunked {
+		h.Set("Transfer-Encoding", "chunked")
+	} else {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.Write([]byte(body))
+	require.NoError(t, err)
+	if chunked {
+		_, err = w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+		require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	}
+}
+
+func decode(t *testing.T, encoding string, data []byte) string {
+	t.Helper()
+
+	var reader io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return string(data)
+	}
+	require.NoError(t, err)
+
+	decoded, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func TestCompression(t *testing.T) {
+	body := strings.Repeat("compressible ", 200)
+
+	// Test: gzip and deflate bodies with Content-Length and chunked framing
+	for _, encoding := range []string{"gzip", "deflate"} {
+		for _, chunked := range []bool{false, true} {
+			r := record(t, func(w *Writer) {
+				w.EnableCompression(encoding)
+				writeText(t, w, "text/plain", body, chunked)
+			})
+			assert.Equal(t, encoding, r.Headers["content-encoding"])
+			assert.Equal(t, "Accept-Encoding", r.Headers["vary"])
+			assert.Less(t, len(r.Body), len(body))
+			assert.Equal(t, body, decode(t, encoding, r.Body))
+			if !chunked {
+				assert.Equal(t, strconv.Itoa(len(r.Body)), r.Headers["content-length"])
+			}
+		}
+	}
+
+	// Test: A declared length too large to hold back is streamed chunked instead
+	large := strings.Repeat("compressible ", compressMaxBuffered/10)
+	r := record(t, func(w *Writer) {
+		w.EnableCompression("gzip")
+		writeText(t, w, "text/plain", large, false)
+	})
+	assert.Equal(t, "gzip", r.Headers["content-encoding"])
+	assert.Equal(t, "chunked", r.Headers["transfer-encoding"])
+	assert.NotContains(t, r.Headers, "content-length")
+	assert.Equal(t, large, decode(t, "gzip", r.Body))
+
+	// Test: Small bodies are sent as is but still vary on Accept-Encoding
+	r = record(t, func(w *Writer) {
+		w.EnableCompression("gzip")
+		writeText(t, w, "text/plain", "tiny", false)
+	})
+	assert.NotContains(t, r.Headers, "content-encoding")
+	assert.Equal(t, "Accept-Encoding", r.Headers["vary"])
+	assert.Equal(t, "tiny", string(r.Body))
+
+	// Test: Clients that accept no coding get identity with Vary
+	r = record(t, func(w *Writer) {
+		w.EnableCompression("")
+		writeText(t, w, "text/plain", body, false)
+	})
+	assert.NotContains(t, r.Headers, "content-encoding")
+	assert.Equal(t, "Accept-Encoding", r.Headers["vary"])
+	assert.Equal(t, body, string(r.Body))
+
+	// Test: Already compressed media types are left alone without Vary
+	r = record(t, func(w *Writer) {
+		w.EnableCompression("gzip")
+		writeText(t, w, "image/png", body, false)
+	})
+	assert.NotContains(t, r.Headers, "content-encoding")
+	assert.NotContains(t, r.Headers, "vary")
+	assert.Equal(t, body, string(r.Body))
+}
+
+func TestAutoFraming(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	write := func(data ...string) *Response {
+		return record(t, func(w *Writer) {
+			w.EnableAutoFraming(64)
+			require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+			h := headers.NewHeaders()
+			h.Set("Content-Type", "text/plain")
+			require.NoError(t, w.WriteHeaders(h))
+			for _, d := range data {
+				_, err := w.Write([]byte(d))
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// Test: Bodies within the threshold get a Content-Length
+	r := write(body[:40], body[:20])
+	assert.Equal(t, "60", r.Headers["content-length"])
+	assert.NotContains(t, r.Headers, "transfer-encoding")
+	assert.Equal(t, body[:60], string(r.Body))
+
+	// Test: Outgrowing the threshold switches to chunked
+	r = write(body[:40], body[:40], body[:20])
+	assert.Equal(t, "chunked", r.Headers["transfer-encoding"])
+	assert.NotContains(t, r.Headers, "content-length")
+	assert.Equal(t, body, string(r.Body))
+
+	// Test: WriteBody keeps framing chunks once the body outgrew the threshold
+	r = record(t, func(w *Writer) {
+		w.EnableAutoFraming(DefaultFrameThreshold)
+		require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+		require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+		for _, part := range []string{strings.Repeat("a", DefaultFrameThreshold), "SECOND", "THIRD"} {
+			_, err := w.WriteBody([]byte(part))
+			require.NoError(t, err)
+		}
+	})
+	assert.Equal(t, "chunked", r.Headers["transfer-encoding"])
+	assert.Equal(t, strings.Repeat("a", DefaultFrameThreshold)+"SECONDTHIRD", string(r.Body))
+
+	// Test: An empty body is framed too
+	r = write()
+	assert.Equal(t, "0", r.Headers["content-length"])
+
+	// Test: Trailers force chunked framing
+	r = record(t, func(w *Writer) {
+		w.EnableAutoFraming(64)
+		require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+		require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+		_, err := w.Write([]byte("short"))
+		require.NoError(t, err)
+		w.SetTrailer("X-Checksum", "abc")
+	})
+	assert.Equal(t, "chunked", r.Headers["transfer-encoding"])
+	assert.Equal(t, "short", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+
+	// Test: A Content-Length chosen by the handler is kept
+	r = record(t, func(w *Writer) {
+		w.EnableAutoFraming(4)
+		writeText(t, w, "text/plain", body, false)
+	})
+	assert.Equal(t, "100", r.Headers["content-length"])
+	assert.Equal(t, body, string(r.Body))
+}
+
+func TestWriterIO(t *testing.T) {
+	body := strings.Repeat("0123456789", 10000)
+
+	// Test: io.Copy goes through ReadFrom into a Content-Length body
+	r := record(t, func(w *Writer) {
+		require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+		require.NoError(t, w.WriteHeaders(headers.GetDefaultHeaders(len(body))))
+		// Hiding WriteTo leaves io.Copy to use the Writer's ReadFrom
+		n, err := io.Copy(w, struct{ io.Reader }{strings.NewReader(body)})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(body)), n)
+	})
+	assert.Equal(t, body, string(r.Body))
+
+	// Test: Write and ReadFrom frame chunks when the response is chunked
+	r = record(t, func(w *Writer) {
+		require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := fmt.Fprint(w, "head ")
+		require.NoError(t, err)
+		_, err = w.ReadFrom(strings.NewReader(body))
+		require.NoError(t, err)
+		_, err = w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+		require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	})
+	assert.Equal(t, "head "+body, string(r.Body))
+
+	// Test: Output stays buffered until Flush
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(headers.GetDefaultHeaders(2)))
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+	assert.Zero(t, buffer.Len())
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(buffer.String(), "\r\n\r\nok"))
+
+	// Test: Writing before the headers fails
+	w = NewWriter(&buffer)
+	_, err = io.Copy(w, strings.NewReader("early"))
+	require.Error(t, err)
+}
+
+// readFromRecorder notes whether the body was handed over through ReadFrom, as a *net.TCPConn would
+// take it for sendfile
+type readFromRecorder struct {
+	bytes.Buffer
+	readFrom bool
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return r.Buffer.ReadFrom(src)
+}
+
+func TestWriteFile(t *testing.T) {
+	body := strings.Repeat("file contents ", 500)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+
+	send := func(setup func(w *Writer), h headers.Headers) (*readFromRecorder, *Response) {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		conn := &readFromRecorder{}
+		w := NewWriter(conn)
+		setup(w)
+		require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+		require.NoError(t, w.WriteHeaders(h))
+		n, err := w.WriteFile(f)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(body)), n)
+		require.NoError(t, w.Finish())
+		require.NoError(t, w.Flush())
+
+		r, err := FromReader(bytes.NewReader(conn.Bytes()))
+		require.NoError(t, err)
+		return conn, r
+	}
+
+	h := headers.GetDefaultHeaders(len(body))
+
+	// Test: Identity bodies are copied straight onto the connection after the buffered headers
+	conn, r := send(func(w *Writer) {}, h)
+	assert.True(t, conn.readFrom)
+	assert.Equal(t, body, string(r.Body))
+
+	// Test: Compressed bodies go through the Writer instead
+	conn, r = send(func(w *Writer) { w.EnableCompression("gzip") }, h)
+	assert.False(t, conn.readFrom)
+	assert.Equal(t, "gzip", r.Headers["content-encoding"])
+	assert.Equal(t, body, decode(t, "gzip", r.Body))
+}
+
+func TestServeContentRanges(t *testing.T) {
+	const content = "abcdefghijklmnopqrstuvwxyz"
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	serve := func(h map[string]string) *Response {
+		req := request.NewRequest("GET", "/file.txt", nil)
+		for key, val := range h {
+			req.Headers.Set(key, val)
+		}
+		return record(t, func(w *Writer) {
+			require.NoError(t, w.ServeContent(req, strings.NewReader(content), int64(len(content)), modTime, "text/plain"))
+		})
+	}
+
+	// Test: Full content advertises ranges
+	r := serve(nil)
+	assert.Equal(t, StatusCode(StatusCodeOK), r.StatusLine.StatusCode)
+	assert.Equal(t, "bytes", r.Headers["accept-ranges"])
+	assert.Equal(t, content, string(r.Body))
+
+	// Test: Single range
+	r = serve(map[string]string{"Range": "bytes=2-5"})
+	assert.Equal(t, StatusCode(StatusCodePartialContent), r.StatusLine.StatusCode)
+	assert.Equal(t, "bytes 2-5/26", r.Headers["content-range"])
+	assert.Equal(t, "4", r.Headers["content-length"])
+	assert.Equal(t, "cdef", string(r.Body))
+
+	// Test: Suffix range
+	r = serve(map[string]string{"Range": "bytes=-3"})
+	assert.Equal(t, "bytes 23-25/26", r.Headers["content-range"])
+	assert.Equal(t, "xyz", string(r.Body))
+
+	// Test: Several ranges are sent as multipart/byteranges
+	r = serve(map[string]string{"Range": "bytes=0-1, 10-12"})
+	assert.Equal(t, StatusCode(StatusCodePartialContent), r.StatusLine.StatusCode)
+	assert.Equal(t, strconv.Itoa(len(r.Body)), r.Headers["content-length"])
+	mediaType, params, err := mime.ParseMediaType(r.Headers["content-type"])
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	require.NotEmpty(t, params["boundary"])
+	assert.True(t, strings.HasSuffix(string(r.Body), "\r\n--"+params["boundary"]+"--\r\n"))
+
+	parts := multipart.NewReader(bytes.NewReader(r.Body), params["boundary"])
+	for _, expected := range []struct{ contentRange, data string }{
+		{"bytes 0-1/26", "ab"},
+		{"bytes 10-12/26", "klm"},
+	} {
+		part, err := parts.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+		assert.Equal(t, expected.contentRange, part.Header.Get("Content-Range"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, expected.data, string(data))
+	}
+	_, err = parts.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Unsatisfiable ranges get 416 with the full length
+	r = serve(map[string]string{"Range": "bytes=100-"})
+	assert.Equal(t, StatusCode(StatusCodeRangeNotSatisfiable), r.StatusLine.StatusCode)
+	assert.Equal(t, "bytes */26", r.Headers["content-range"])
+	assert.Empty(t, r.Body)
+
+	// Test: A stale If-Range gets the full content
+	r = serve(map[string]string{"Range": "bytes=2-5", "If-Range": `"stale"`})
+	assert.Equal(t, StatusCode(StatusCodeOK), r.StatusLine.StatusCode)
+	assert.Equal(t, content, string(r.Body))
+
+	// Test: A current If-Range gets the range
+	r = serve(map[string]string{"Range": "bytes=2-5", "If-Range": FileETag(int64(len(content)), modTime)})
+	assert.Equal(t, StatusCode(StatusCodePartialContent), r.StatusLine.StatusCode)
+	assert.Equal(t, "cdef", string(r.Body))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	const content = "versioned content"
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	etag := FileETag(int64(len(content)), modTime)
+	serve := func(key, val string) *Response {
+		req := request.NewRequest("GET", "/file.txt", nil)
+		req.Headers.Set(key, val)
+		return record(t, func(w *Writer) {
+			require.NoError(t, w.ServeContent(req, strings.NewReader(content), int64(len(content)), modTime, "text/plain"))
+		})
+	}
+
+	// Test: A matching If-None-Match gets 304 with the validators and no body
+	r := serve("If-None-Match", etag)
+	assert.Equal(t, StatusCode(StatusCodeNotModified), r.StatusLine.StatusCode)
+	assert.Equal(t, etag, r.Headers["etag"])
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", r.Headers["last-modified"])
+	assert.NotContains(t, r.Headers, "content-length")
+	assert.Empty(t, r.Body)
+
+	// Test: A weak match is enough for If-None-Match
+	r = serve("If-None-Match", `"other", W/`+etag)
+	assert.Equal(t, StatusCode(StatusCodeNotModified), r.StatusLine.StatusCode)
+
+	// Test: Unchanged since If-Modified-Since
+	r = serve("If-Modified-Since", "Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, StatusCode(StatusCodeNotModified), r.StatusLine.StatusCode)
+
+	// Test: Modified since If-Modified-Since
+	r = serve("If-Modified-Since", "Tue, 30 Apr 2024 12:00:00 GMT")
+	assert.Equal(t, StatusCode(StatusCodeOK), r.StatusLine.StatusCode)
+	assert.Equal(t, content, string(r.Body))
+
+	// Test: A failed If-Match gets 412
+	r = serve("If-Match", `"other"`)
+	assert.Equal(t, StatusCode(StatusCodePreconditionFailed), r.StatusLine.StatusCode)
+	assert.Equal(t, "0", r.Headers["content-length"])
+	assert.Empty(t, r.Body)
+
+	// Test: Modified after If-Unmodified-Since gets 412
+	r = serve("If-Unmodified-Since", "Tue, 30 Apr 2024 12:00:00 GMT")
+	assert.Equal(t, StatusCode(StatusCodePreconditionFailed), r.StatusLine.StatusCode)
+
+	// Test: A matching If-Match proceeds
+	r = serve("If-Match", etag)
+	assert.Equal(t, StatusCode(StatusCodeOK), r.StatusLine.StatusCode)
+	assert.Equal(t, content, string(r.Body))
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                      "",
+		"gzip":                  "gzip",
+		"deflate":               "deflate",
+		"deflate, gzip":         "gzip",
+		"gzip;q=0.5, deflate":   "deflate",
+		"*":                     "gzip",
+		"gzip;q=0, *":           "deflate",
+		"gzip;q=0, deflate;q=0": "",
+		"*;q=0":                 "",
+		"br, identity":          "",
+		"GZIP":                  "gzip",
+	} {
+		// Test: Explicit codings outrank * and q=0 excludes them
+		assert.Equal(t, expected, negotiateEncoding(header), header)
+	}
+
+	// Test: An excluded coding is not applied to the body
+	r := record(t, func(w *Writer) {
+		w.EnableCompression("gzip;q=0, *")
+		writeText(t, w, "text/plain", strings.Repeat("compressible ", 200), false)
+	})
+	assert.Equal(t, "deflate", r.Headers["content-encoding"])
+}
+
+func TestWriteProblem(t *testing.T) {
+	// Test: A problem without a status is sent as a 500
+	r := record(t, func(w *Writer) {
+		require.NoError(t, WriteProblem(w, Problem{Detail: "boom"}))
+	})
+	assert.Equal(t, StatusCode(StatusCodeInternalServerError), r.StatusLine.StatusCode)
+	assert.Equal(t, "Internal Server Error", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "application/problem+json", r.Headers["content-type"])
+	assert.JSONEq(t, `{"title":"Internal Server Error","status":500,"detail":"boom"}`, string(r.Body))
+
+	// Test: An explicit status is kept
+	r = record(t, func(w *Writer) {
+		require.NoError(t, WriteProblem(w, Problem{Status: StatusCodeNotFound}))
+	})
+	assert.Equal(t, StatusCode(StatusCodeNotFound), r.StatusLine.StatusCode)
+	assert.JSONEq(t, `{"title":"Not Found","status":404}`, string(r.Body))
+}
+
+func TestReset(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	writeText(t, w, "text/plain", "partial", false)
+
+	// Test: Buffered output is not committed and can be replaced
+	assert.False(t, w.Committed())
+	require.NoError(t, w.Reset())
+	require.NoError(t, w.WriteError(StatusCodeInternalServerError))
+	require.NoError(t, w.Flush())
+	assert.True(t, w.Committed())
+
+	r, err := FromReader(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(StatusCodeInternalServerError), r.StatusLine.StatusCode)
+
+	// Test: Once bytes reach the connection the response cannot be replaced
+	require.Error(t, w.Reset())
+}
+
+func TestReadHead(t *testing.T) {
+	raw := "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5\r\nhello\r\n7;ext=1\r\n, world\r\n0\r\nX-Sum: 42\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nnext" +
+		"HTTP/1.0 200 OK\r\n\r\nuntil the end"
+	reader := bufio.NewReaderSize(&chunkReader{data: raw, numBytesPerRead: 3}, 32)
+
+	// Test: Interim responses are skipped and a chunked body streams with its trailers
+	r, err := ReadHead(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(StatusCodeOK), r.StatusLine.StatusCode)
+	body, delimited := r.BodyReader()
+	assert.True(t, delimited)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(data))
+	assert.Equal(t, "42", r.Trailers["x-sum"])
+
+	// Test: A response without a body has no reader
+	r, err = ReadHead(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(StatusCodeNoContent), r.StatusLine.StatusCode)
+	body, delimited = r.BodyReader()
+	assert.Nil(t, body)
+	assert.True(t, delimited)
+
+	// Test: A Content-Length body stops where the next response begins
+	r, err = ReadHead(reader, "GET")
+	require.NoError(t, err)
+	body, _ = r.BodyReader()
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "next", string(data))
+
+	// Test: A body without framing runs until the connection closes
+	r, err = ReadHead(reader, "GET")
+	require.NoError(t, err)
+	body, delimited = r.BodyReader()
+	assert.False(t, delimited)
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(data))
+
+	// Test: A body cut short is reported
+	r, err = ReadHead(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")), "GET")
+	require.NoError(t, err)
+	body, _ = r.BodyReader()
+	_, err = io.ReadAll(body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: A line longer than the reader's buffer is refused
+	_, err = ReadHead(bufio.NewReaderSize(strings.NewReader("HTTP/1.1 200 OK\r\nX-Long: "+strings.Repeat("a", 64)+"\r\n\r\n"), 16), "GET")
+	require.Error(t, err)
+}
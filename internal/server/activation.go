@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFdsStart is the first descriptor passed by systemd, right after stdin, stdout and stderr
+const listenFdsStart = 3
+
+// ActivationListeners returns the listeners passed in through LISTEN_FDS, as done by systemd socket
+// activation, or none when the process was started normally. LISTEN_PID must match this process when
+// set. A parent re-executing itself cannot know the child's pid up front, so it may leave it out. The
+// variables are cleared so they do not leak into processes started later
+func ActivationListeners() ([]net.Listener, error) {
+	fdsStr, exists := os.LookupEnv("LISTEN_FDS")
+	if !exists {
+		return nil, nil
+	}
+
+	pidStr, pidSet := os.LookupEnv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pidSet && pidStr != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(fdsStr)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", fdsStr)
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		// FileListener works on a duplicate, so the original descriptor is no longer needed
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("fd %d is not a listening socket: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
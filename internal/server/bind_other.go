@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+import "net"
+
+// bindPrivate has no umask to narrow outside Unix
+func bindPrivate(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// bindPrivate creates the socket file accessible to its owner only, so nobody else can connect in the
+// moment before its mode is applied. The umask is process wide, so files created elsewhere meanwhile
+// only ever end up more private
+func bindPrivate(network, address string) (net.Listener, error) {
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+	return net.Listen(network, address)
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	devCAValidity   = 10 * 365 * 24 * time.Hour
+	devLeafValidity = 825 * 24 * time.Hour
+	// devLeafRenewal renews the leaf this long before it expires
+	devLeafRenewal = 30 * 24 * time.Hour
+)
+
+// DevCertificate are the files written by GenerateDevCertificate
+type DevCertificate struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// GenerateDevCertificate makes sure dir holds a local CA and a certificate for localhost signed by it,
+// creating them on first use and renewing the leaf when it is close to expiring. Trusting CAFile once
+// is enough for every leaf issued later
+func GenerateDevCertificate(dir string) (*DevCertificate, error) {
+	dc := &DevCertificate{
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "localhost.crt"),
+		KeyFile:  filepath.Join(dir, "localhost.key"),
+	}
+	caKeyFile := filepath.Join(dir, "ca.key")
+
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	ca, caKey, err := loadPair(dc.CAFile, caKeyFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("error loading dev CA: %w", err)
+		}
+
+		ca, caKey, err = createDevCA(dc.CAFile, caKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		// A leaf signed by a previous CA is useless now
+		_ = os.Remove(dc.CertFile)
+	}
+
+	leaf, _, err := loadPair(dc.CertFile, dc.KeyFile)
+	if err == nil && time.Until(leaf.NotAfter) > devLeafRenewal && leaf.CheckSignatureFrom(ca) == nil {
+		return dc, nil
+	}
+
+	err = createDevLeaf(dc.CertFile, dc.KeyFile, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+func createDevCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "tcp-to-http development CA", Organization: []string{"tcp-to-http"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writeFiles(certFile, keyFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ca, key, nil
+}
+
+func createDevLeaf(certFile, keyFile string, ca *x509.Certificate, caKey crypto.Signer) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "localhost", Organization: []string{"tcp-to-http"}},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(devLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return err
+	}
+
+	// Serve the chain so clients that only trust the CA can verify it
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	return writeFiles(certFile, keyFile, chain, key)
+}
+
+// loadPair reads a certificate and the private key matching it
+func loadPair(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported private key")
+	}
+	return pair.Leaf, signer, nil
+}
+
+func writeFiles(certFile, keyFile string, certPEM []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, certPEM, 0o644)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
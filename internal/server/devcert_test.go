@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateDevCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	dc, err := GenerateDevCertificate(dir)
+	require.NoError(t, err)
+
+	caPEM, err := os.ReadFile(dc.CAFile)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+
+	pair, err := tls.LoadX509KeyPair(dc.CertFile, dc.KeyFile)
+	require.NoError(t, err)
+
+	// Test: The leaf is trusted for localhost and the loopback addresses through the CA
+	for _, name := range []string{"localhost", "127.0.0.1", "::1"} {
+		_, err = pair.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+		assert.NoError(t, err, name)
+	}
+
+	// Test: The key stays private
+	info, err := os.Stat(dc.KeyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Test: Later runs reuse the cached files
+	again, err := GenerateDevCertificate(dir)
+	require.NoError(t, err)
+	assert.Equal(t, dc, again)
+	pairAgain, err := tls.LoadX509KeyPair(again.CertFile, again.KeyFile)
+	require.NoError(t, err)
+	assert.Equal(t, pair.Leaf.SerialNumber, pairAgain.Leaf.SerialNumber)
+
+	// Test: A missing leaf is reissued by the same CA
+	require.NoError(t, os.Remove(dc.CertFile))
+	_, err = GenerateDevCertificate(dir)
+	require.NoError(t, err)
+	caAgain, err := os.ReadFile(dc.CAFile)
+	require.NoError(t, err)
+	assert.Equal(t, caPEM, caAgain)
+	pairAgain, err = tls.LoadX509KeyPair(dc.CertFile, dc.KeyFile)
+	require.NoError(t, err)
+	_, err = pairAgain.Leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/proxyproto"
+)
+
+// maxPacketSize bounds a single message read off a unixpacket socket
+const maxPacketSize = 1 << 16
+
+// WithSocketMode sets the permissions of the socket file when listening on a Unix socket
+func WithSocketMode(mode os.FileMode) Option {
+	return func(s *Server) {
+		s.socketMode = mode
+	}
+}
+
+func listen(network, address string, mode os.FileMode) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return net.Listen(network, address)
+	case "unix", "unixpacket":
+	default:
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+
+	// Abstract sockets have no file to clean up or protect
+	abstract := strings.HasPrefix(address, "@")
+	if !abstract {
+		err := removeStaleSocket(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bind := net.Listen
+	if !abstract && mode != 0 {
+		bind = bindPrivate
+	}
+
+	listener, err := bind(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !abstract && mode != 0 {
+		err = os.Chmod(address, mode)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	if network == "unixpacket" {
+		return &packetListener{listener}, nil
+	}
+	return listener, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a process that did not shut down cleanly. A
+// socket something is still accepting on is left alone
+func removeStaleSocket(network, path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout(network, path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+type packetListener struct {
+	net.Listener
+}
+
+func (l *packetListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &packetConn{Conn: conn, reader: bufio.NewReaderSize(conn, maxPacketSize)}, nil
+}
+
+// packetConn reads whole messages off a unixpacket socket, where a read shorter than the message
+// discards the rest of it, and hands them out in whatever sizes the parser asks for
+type packetConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *packetConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// unwrap returns the socket underneath any TLS, PROXY protocol or message framing layers
+func unwrap(conn net.Conn) net.Conn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *proxyproto.Conn:
+			conn = c.NetConn()
+		case *packetConn:
+			conn = c.Conn
+		default:
+			return conn
+		}
+	}
+}
+
+func remoteAddr(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
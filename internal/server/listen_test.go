@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func peerHandler(w *response.Writer, req *request.Request) *HandlerError {
+	body := "addr=" + req.RemoteAddr
+	if req.Peer != nil {
+		body += fmt.Sprintf(" uid=%d pid=%d", req.Peer.UID, req.Peer.PID)
+	}
+	_ = w.WriteStatusLine(response.StatusCodeOK)
+	_ = w.WriteHeaders(headers.GetDefaultHeaders(len(body)))
+	_, _ = w.Write([]byte(body))
+	return nil
+}
+
+func roundTrip(t *testing.T, network, address string) string {
+	t.Helper()
+
+	conn, err := net.Dial(network, address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	// Read through a buffer so whole messages are taken off unixpacket sockets
+	res, err := response.FromReader(bufio.NewReaderSize(conn, maxPacketSize))
+	require.NoError(t, err)
+	return string(res.Body)
+}
+
+func TestBindPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no umask on Windows")
+	}
+
+	// Test: The socket file starts out closed to everyone but its owner
+	path := filepath.Join(t.TempDir(), "http.sock")
+	listener, err := bindPrivate("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, info.Mode().Perm()&0o077, info.Mode().Perm())
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	srv, err := Listen("unix", path, peerHandler, WithSocketMode(0o660))
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o660), info.Mode().Perm())
+
+	// Test: Peer credentials are exposed for Unix sockets
+	body := roundTrip(t, "unix", path)
+	if runtime.GOOS == "linux" {
+		assert.Contains(t, body, fmt.Sprintf("uid=%d pid=%d", os.Getuid(), os.Getpid()))
+	}
+
+	// Test: A socket in use is not taken over
+	_, err = Listen("unix", path, peerHandler)
+	require.Error(t, err)
+
+	// Test: Closing removes the socket file
+	require.NoError(t, srv.Close())
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: A stale socket left by a crashed process is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv, err = Listen("unix", path, peerHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	assert.True(t, strings.HasPrefix(roundTrip(t, "unix", path), "addr="))
+
+	// Test: Anything other than a socket is never removed
+	regular := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(regular, nil, 0o600))
+	_, err = Listen("unix", regular, peerHandler)
+	require.Error(t, err)
+	_, err = os.Stat(regular)
+	require.NoError(t, err)
+}
+
+func TestListenUnixPacket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unixpacket is Linux only")
+	}
+
+	path := filepath.Join(t.TempDir(), "http.sock")
+	srv, err := Listen("unixpacket", path, peerHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Whole requests arrive even though the parser reads in small pieces
+	body := roundTrip(t, "unixpacket", path)
+	assert.Contains(t, body, fmt.Sprintf("uid=%d", os.Getuid()))
+}
+
+func TestListenTCP(t *testing.T) {
+	srv, err := Listen("tcp4", "127.0.0.1:0", peerHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: The peer address is exposed and there are no credentials over TCP
+	body := roundTrip(t, "tcp4", srv.Addr().String())
+	assert.True(t, strings.HasPrefix(body, "addr=127.0.0.1:"))
+	assert.NotContains(t, body, "uid=")
+
+	_, err = Listen("udp", "127.0.0.1:0", peerHandler)
+	require.Error(t, err)
+}
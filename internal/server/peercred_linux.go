@@ -0,0 +1,33 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+)
+
+// peerCredentials reads SO_PEERCRED, returning nil for anything but a Unix socket
+func peerCredentials(conn net.Conn) *request.PeerCredentials {
+	unixConn, ok := unwrap(conn).(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var cred *syscall.Ucred
+	err = raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return nil
+	}
+
+	return &request.PeerCredentials{PID: int(cred.Pid), UID: int(cred.Uid), GID: int(cred.Gid)}
+}
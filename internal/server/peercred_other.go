@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+)
+
+// peerCredentials is only implemented on Linux
+func peerCredentials(conn net.Conn) *request.PeerCredentials {
+	return nil
+}
@@ -1,63 +1,283 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"os"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/proxyproto"
 	"github.com/MadhurSahu/tcp-to-http/internal/request"
 	"github.com/MadhurSahu/tcp-to-http/internal/response"
 )
 
 type Server struct {
-	closed   atomic.Bool
-	handler  Handler
-	listener net.Listener
+	ctx               context.Context
+	cancel            context.CancelFunc
+	closed            atomic.Bool
+	handler           Handler
+	listener          net.Listener
+	maxDecompressSize int64
+	maxBodySize       int
+	certificates      []*certificate
+	tlsConfig         *tls.Config
+	socketMode        os.FileMode
+	base              net.Listener
+	proxyProtocol     bool
+	proxyOptions      []proxyproto.Option
+	listening         chan struct{}
+	conns             sync.WaitGroup
 }
 
+const handshakeTimeout = 10 * time.Second
+
 type HandlerError struct {
 	StatusCode response.StatusCode
+	Message    string
+	Headers    headers.Headers
+	// Body replaces the generated error page. Headers must then carry its Content-Type
+	Body []byte
+	Err  error
 }
 
 type Handler func(w *response.Writer, req *request.Request) *HandlerError
 
+type Option func(*Server)
+
+func WithDecompression(maxSize int64) Option {
+	return func(s *Server) {
+		s.maxDecompressSize = maxSize
+	}
+}
+
+// WithMaxBodySize refuses requests with a body longer than maxSize with a 413. Without this option
+// request.DefaultMaxBodySize applies
+func WithMaxBodySize(maxSize int) Option {
+	return func(s *Server) {
+		s.maxBodySize = maxSize
+	}
+}
+
+// WithProxyProtocol expects a PROXY protocol header from load balancers in front of the server, so
+// Request.RemoteAddr is the actual client. Only peers given with proxyproto.WithTrustedSources are
+// believed
+func WithProxyProtocol(opts ...proxyproto.Option) Option {
+	return func(s *Server) {
+		s.proxyProtocol = true
+		s.proxyOptions = opts
+	}
+}
+
+func AutoFrame(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		w.EnableAutoFraming(response.DefaultFrameThreshold)
+		return next(w, req)
+	}
+}
+
+func Compress(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+		w.EnableCompression(acceptEncoding)
+		return next(w, req)
+	}
+}
+
+func (e *HandlerError) Error() string {
+	str := fmt.Sprintf("%d %s", e.StatusCode, response.StatusText(e.StatusCode))
+	if e.Message != "" {
+		str += ": " + e.Message
+	}
+	if e.Err != nil {
+		str += ": " + e.Err.Error()
+	}
+	return str
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	s.cancel()
 	if s.listener != nil {
 		s.listener.Close()
 	}
 	return nil
 }
 
+// Shutdown stops accepting connections and waits for the ones in flight to finish. Once ctx is done
+// the remaining handlers see their request context cancelled and Shutdown returns ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closed.Store(true)
+	s.listener.Close()
+	<-s.listening
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	defer s.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ListenerFile returns a duplicate of the listening socket, to be handed to another process
+func (s *Server) ListenerFile() (*os.File, error) {
+	switch l := s.baseListener().(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		return l.File()
+	default:
+		return nil, fmt.Errorf("cannot get a file for a %T", l)
+	}
+}
+
+// KeepSocketFile leaves the socket file of a Unix listener in place when this server closes. Call it
+// once the process given ListenerFile is serving, and not before, so a failed handover does not leave
+// a stale file behind
+func (s *Server) KeepSocketFile() {
+	if l, ok := s.baseListener().(*net.UnixListener); ok {
+		l.SetUnlinkOnClose(false)
+	}
+}
+
+func (s *Server) baseListener() net.Listener {
+	if packet, ok := s.base.(*packetListener); ok {
+		return packet.Listener
+	}
+	return s.base
+}
+
 func (s *Server) handle(conn net.Conn) {
+	res := response.NewWriter(conn)
 	defer func() {
+		if res.Hijacked() {
+			return
+		}
+
+		err := res.Flush()
+		if err != nil {
+			log.Println(err)
+		}
 		time.Sleep(50 * time.Millisecond)
 		conn.Close()
 	}()
-	res := response.NewWriter(conn)
 
-	req, err := request.FromReader(conn)
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(s.ctx, handshakeTimeout)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+
+	req, err := request.FromReaderWithLimit(conn, s.maxBodySize)
 	if err != nil {
-		err := res.WriteError(response.StatusCodeBadRequest)
+		code := response.StatusCode(response.StatusCodeBadRequest)
+		if errors.Is(err, request.ErrRequestBodyTooLarge) {
+			code = response.StatusCodeRequestEntityTooLarge
+		}
+
+		err := res.WriteError(code)
 		if err != nil {
 			log.Println(err)
 		}
 		return
 	}
 
+	req.RemoteAddr = remoteAddr(conn)
+	req.Peer = peerCredentials(conn)
+	req.TLS = tlsState
+	res.SetBuffered(req.Buffered())
+
+	if s.maxDecompressSize > 0 {
+		err := req.Decompress(s.maxDecompressSize)
+		if err != nil {
+			code := response.StatusCode(response.StatusCodeBadRequest)
+			if errors.Is(err, request.ErrUnsupportedEncoding) {
+				code = response.StatusCodeUnsupportedMediaType
+			} else if errors.Is(err, request.ErrBodyTooLarge) {
+				code = response.StatusCodeRequestEntityTooLarge
+			}
+
+			err := res.WriteErrorFor(req, code)
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+
 	hErr := s.handler(res, req)
+	if hErr != nil && res.Hijacked() {
+		log.Printf("Error after hijacking %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, hErr)
+		return
+	}
+
 	if hErr != nil {
-		err := res.WriteError(hErr.StatusCode)
+		if hErr.Err != nil {
+			log.Printf("Error handling %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, hErr)
+		}
+
+		if res.Committed() {
+			abort(conn, res, hErr)
+			return
+		}
+
+		// Nothing reached the client yet, so whatever the handler buffered gives way to the error page
+		err := res.Reset()
+		if err == nil {
+			err = s.writeHandlerError(res, req, hErr)
+		}
 		if err != nil {
 			log.Println(err)
 		}
 		return
 	}
+
+	if res.Hijacked() {
+		return
+	}
+
+	err = res.Finish()
+	if err != nil {
+		log.Println(err)
+	}
 }
 
 func (s *Server) listen() {
+	defer close(s.listening)
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
@@ -67,22 +287,105 @@ func (s *Server) listen() {
 			log.Printf("Error accepting connection: %f", err)
 			continue
 		}
-		go s.handle(conn)
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) writeHandlerError(res *response.Writer, req *request.Request, hErr *HandlerError) error {
+	if hErr.Body == nil {
+		return res.WriteErrorDetail(req, hErr.StatusCode, hErr.Message, hErr.Headers)
+	}
+
+	if _, exists := hErr.Headers.Get("Content-Type"); !exists {
+		log.Printf("Error body for %s %s has no Content-Type, sending the default page", req.RequestLine.Method, req.RequestLine.RequestTarget)
+		return res.WriteErrorDetail(req, hErr.StatusCode, hErr.Message, hErr.Headers)
+	}
+	return res.WriteErrorBody(hErr.StatusCode, hErr.Body, hErr.Headers)
+}
+
+// abort gives up on a response the handler already started. A chunked body is cut short with an
+// error trailer, anything else is reset so the client cannot mistake it for a complete response
+func abort(conn net.Conn, res *response.Writer, hErr *HandlerError) {
+	log.Printf("Response already committed, aborting: %v", hErr)
+
+	err := res.EndChunkedWithError(hErr.StatusCode, hErr.Message)
+	if err == nil {
+		return
+	}
+
+	res.Abort()
+	if tcpConn, ok := unwrap(conn).(*net.TCPConn); ok {
+		err := tcpConn.SetLinger(0)
+		if err != nil {
+			log.Println(err)
+		}
 	}
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	addr := ":" + strconv.Itoa(port)
-	listener, err := net.Listen("tcp", addr)
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	return Listen("tcp", ":"+strconv.Itoa(port), handler, opts...)
+}
+
+// Listen serves on any of the tcp, tcp4, tcp6, unix and unixpacket networks. A socket file left over
+// from an earlier run is replaced
+func Listen(network, address string, handler Handler, opts ...Option) (*Server, error) {
+	server := newServer(handler, opts)
+
+	listener, err := listen(network, address, server.socketMode)
 	if err != nil {
+		server.cancel()
 		return nil, err
 	}
+	return server.serve(listener)
+}
+
+// ServeListener serves on a listener created elsewhere, such as one inherited from systemd. The
+// listener is closed if the server cannot start
+func ServeListener(listener net.Listener, handler Handler, opts ...Option) (*Server, error) {
+	// A unixpacket listener from net.FileListener still needs its messages framed
+	if _, ok := listener.(*net.UnixListener); ok && listener.Addr().Network() == "unixpacket" {
+		listener = &packetListener{listener}
+	}
+	return newServer(handler, opts).serve(listener)
+}
 
+func newServer(handler Handler, opts []Option) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	server := &Server{
-		listener: listener,
-		handler:  handler,
+		ctx:         ctx,
+		cancel:      cancel,
+		handler:     handler,
+		maxBodySize: request.DefaultMaxBodySize,
+		listening:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(server)
+	}
+	return server
+}
+
+func (s *Server) serve(listener net.Listener) (*Server, error) {
+	tlsConfig, err := s.buildTLSConfig()
+	if err != nil {
+		listener.Close()
+		s.cancel()
+		return nil, err
+	}
+
+	s.base = listener
+	// The PROXY header comes first on the wire, ahead of any TLS handshake
+	if s.proxyProtocol {
+		listener = proxyproto.NewListener(listener, s.proxyOptions...)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
 	}
-	go server.listen()
+	s.listener = listener
+	go s.listen()
 
-	return server, nil
+	return s, nil
 }
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/proxyproto"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(headers.GetDefaultHeaders(4))
+		_, _ = w.Write([]byte("done"))
+		return nil
+	}
+
+	srv, err := Listen("tcp", "127.0.0.1:0", handler)
+	require.NoError(t, err)
+	addr := srv.Addr().String()
+
+	result := make(chan string)
+	go func() {
+		result <- roundTrip(t, "tcp", addr)
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- srv.Shutdown(context.Background())
+	}()
+
+	// Test: New connections are refused while the request in flight is still served
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, "done", <-result)
+	require.NoError(t, <-shutdown)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		<-req.Context().Done()
+		close(cancelled)
+		return nil
+	}
+
+	srv, err := Listen("tcp", "127.0.0.1:0", handler)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	// Test: Handlers still running at the deadline see their context cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("request context not cancelled")
+	}
+}
+
+func TestListenerFile(t *testing.T) {
+	handover := func(network, path string) (*Server, *Server) {
+		old, err := Listen(network, path, peerHandler)
+		require.NoError(t, err)
+
+		file, err := old.ListenerFile()
+		require.NoError(t, err)
+		listener, err := net.FileListener(file)
+		file.Close()
+		require.NoError(t, err)
+
+		srv, err := ServeListener(listener, peerHandler)
+		require.NoError(t, err)
+		t.Cleanup(func() { srv.Close() })
+		return old, srv
+	}
+
+	for _, network := range []string{"unix", "unixpacket"} {
+		// Test: The socket file survives the old server shutting down and the new one keeps serving
+		path := filepath.Join(t.TempDir(), "http.sock")
+		old, _ := handover(network, path)
+		old.KeepSocketFile()
+		require.NoError(t, old.Shutdown(context.Background()))
+		_, err := os.Stat(path)
+		require.NoError(t, err, network)
+		assert.Contains(t, roundTrip(t, network, path), "addr=", network)
+	}
+
+	// Test: Handing out the listener alone does not keep the socket file, in case the handover fails
+	path := filepath.Join(t.TempDir(), "http.sock")
+	old, _ := handover("unix", path)
+	require.NoError(t, old.Shutdown(context.Background()))
+	_, err := os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	srv, err := Listen("tcp", "127.0.0.1:0", peerHandler, WithProxyProtocol(proxyproto.WithTrustedSources(netip.MustParsePrefix("127.0.0.0/8"))))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: The request carries the client address from the header rather than the load balancer's
+	_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := response.FromReader(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "addr=192.0.2.1:56324", string(res.Body))
+
+	// Test: Connections without a header are refused
+	body := roundTrip(t, "tcp", srv.Addr().String())
+	assert.NotContains(t, body, "addr=")
+}
+
+// exchange serves a single raw request with handler and returns the raw response
+func exchange(t *testing.T, handler Handler, raw string) string {
+	t.Helper()
+
+	data, err := tryExchange(t, handler, raw)
+	require.NoError(t, err)
+	return data
+}
+
+// tryExchange is exchange for responses that may end in a connection reset
+func tryExchange(t *testing.T, handler Handler, raw string) (string, error) {
+	t.Helper()
+
+	srv, err := Listen("tcp", "127.0.0.1:0", handler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	return string(data), err
+}
+
+func TestCompressAutoFrame(t *testing.T) {
+	body := strings.Repeat("compressible ", 200)
+	handler := Compress(AutoFrame(func(w *response.Writer, req *request.Request) *HandlerError {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		h.Set("Content-Length", strconv.Itoa(len(body)+100))
+		_ = w.WriteHeaders(h)
+		_, _ = w.Write([]byte(body))
+		return nil
+	}))
+
+	// Test: A compressed body shorter than its declared length is still finished and sent
+	raw := exchange(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	res, err := response.FromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers["content-encoding"])
+	assert.Equal(t, strconv.Itoa(len(res.Body)), res.Headers["content-length"])
+
+	gz, err := gzip.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+}
+
+func TestHandlerError(t *testing.T) {
+	fail := func(hErr *HandlerError) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			return hErr
+		}
+	}
+	parse := func(raw string) *response.Response {
+		res, err := response.FromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: A custom body is sent with the handler's headers
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "application/xml")
+	h.Set("Retry-After", "30")
+	res := parse(exchange(t, fail(&HandlerError{
+		StatusCode: response.StatusCodeBadGateway,
+		Headers:    h,
+		Body:       []byte("<error>upstream</error>"),
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Equal(t, response.StatusCode(response.StatusCodeBadGateway), res.StatusLine.StatusCode)
+	assert.Equal(t, "application/xml", res.Headers["content-type"])
+	assert.Equal(t, "30", res.Headers["retry-after"])
+	assert.Equal(t, "23", res.Headers["content-length"])
+	assert.Equal(t, "<error>upstream</error>", string(res.Body))
+
+	// Test: A body without a Content-Type falls back to the generated page
+	res = parse(exchange(t, fail(&HandlerError{
+		StatusCode: response.StatusCodeNotFound,
+		Body:       []byte("{}"),
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Equal(t, response.StatusCode(response.StatusCodeNotFound), res.StatusLine.StatusCode)
+	assert.Equal(t, "text/html", res.Headers["content-type"])
+	assert.Contains(t, string(res.Body), "<h1>Not Found</h1>")
+
+	// Test: The message is rendered in the format the client prefers
+	res = parse(exchange(t, fail(&HandlerError{
+		StatusCode: response.StatusCodeForbidden,
+		Message:    "no access",
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\nAccept: application/json\r\n\r\n"))
+	assert.Equal(t, "application/problem+json", res.Headers["content-type"])
+	assert.Contains(t, string(res.Body), `"detail":"no access"`)
+}
+
+func TestCompressServeContent(t *testing.T) {
+	content := strings.Repeat("resumable download ", 200)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	handler := Compress(func(w *response.Writer, req *request.Request) *HandlerError {
+		_ = w.ServeContent(req, strings.NewReader(content), int64(len(content)), modTime, "text/plain")
+		return nil
+	})
+	get := func(extra string) *response.Response {
+		raw := exchange(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n"+extra+"\r\n")
+		res, err := response.FromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: A compressed 200 carries a weak ETag, since the strong one describes the identity bytes
+	full := get("")
+	assert.Equal(t, "gzip", full.Headers["content-encoding"])
+	etag := full.Headers["etag"]
+	assert.True(t, strings.HasPrefix(etag, "W/"), etag)
+
+	// Test: Ranges are identity encoded with the strong ETag
+	partial := get("Range: bytes=0-9\r\n")
+	assert.Equal(t, response.StatusCode(response.StatusCodePartialContent), partial.StatusLine.StatusCode)
+	assert.NotContains(t, partial.Headers, "content-encoding")
+	assert.Equal(t, strings.TrimPrefix(etag, "W/"), partial.Headers["etag"])
+	assert.Equal(t, "Accept-Encoding", partial.Headers["vary"])
+
+	// Test: Resuming the compressed download with its ETag restarts it instead of splicing in identity bytes
+	resumed := get("Range: bytes=100-\r\nIf-Range: " + etag + "\r\n")
+	assert.Equal(t, response.StatusCode(response.StatusCodeOK), resumed.StatusLine.StatusCode)
+	assert.Equal(t, "gzip", resumed.Headers["content-encoding"])
+
+	// Test: The weak ETag still revalidates, and the 304 carries the same ETag and Vary as the 200
+	assert.Equal(t, "Accept-Encoding", full.Headers["vary"])
+	revalidated := get("If-None-Match: " + etag + "\r\n")
+	assert.Equal(t, response.StatusCode(response.StatusCodeNotModified), revalidated.StatusLine.StatusCode)
+	assert.Equal(t, etag, revalidated.Headers["etag"])
+	assert.Equal(t, "Accept-Encoding", revalidated.Headers["vary"])
+}
+
+func TestHandlerErrorAfterWrite(t *testing.T) {
+	fail := &HandlerError{StatusCode: response.StatusCodeInternalServerError, Message: "disk gone"}
+	start := func(w *response.Writer, h headers.Headers) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		h.Set("Content-Type", "text/plain")
+		_ = w.WriteHeaders(h)
+		_, _ = w.Write([]byte("partial"))
+	}
+
+	// Test: Output still in the buffer is replaced by a clean error page
+	raw := exchange(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		h := headers.NewHeaders()
+		h.Set("Content-Length", "100")
+		start(w, h)
+		return fail
+	}, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	res, err := response.FromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(response.StatusCodeInternalServerError), res.StatusLine.StatusCode)
+	assert.Contains(t, string(res.Body), "disk gone")
+	assert.NotContains(t, raw, "partial")
+
+	// Test: A chunked body already on the wire ends with an X-Error trailer
+	raw = exchange(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		start(w, h)
+		_ = w.Flush()
+		return fail
+	}, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	res, err = response.FromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(response.StatusCodeOK), res.StatusLine.StatusCode)
+	assert.Equal(t, "partial", string(res.Body))
+	assert.Equal(t, "500 Internal Server Error: disk gone", res.Trailers["x-error"])
+
+	// Test: A fixed-length body already on the wire is cut off with a reset
+	raw, err = tryExchange(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		h := headers.NewHeaders()
+		h.Set("Content-Length", "100")
+		start(w, h)
+		_ = w.Flush()
+		return fail
+	}, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.NotContains(t, raw, "disk gone")
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval bounds how often certificate files are checked for changes on disk
+const certCheckInterval = time.Second
+
+type certificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+	checked time.Time
+}
+
+// WithTLS serves HTTPS using the certificate and key files. It can be given several times, in which
+// case the certificate is picked by SNI. Files replaced on disk are picked up without a restart
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.certificates = append(s.certificates, &certificate{certFile: certFile, keyFile: keyFile})
+	}
+}
+
+// WithTLSConfig serves HTTPS using config as the base configuration, for instance to require client
+// certificates. Certificates added with WithTLS take precedence over the ones in config
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// buildTLSConfig returns nil when TLS is not enabled
+func (s *Server) buildTLSConfig() (*tls.Config, error) {
+	if s.tlsConfig == nil && len(s.certificates) == 0 {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	}
+	config.NextProtos = []string{"http/1.1"}
+
+	if len(s.certificates) > 0 {
+		for _, c := range s.certificates {
+			err := c.reload()
+			if err != nil {
+				return nil, err
+			}
+		}
+		config.GetCertificate = s.getCertificate
+	}
+
+	if config.GetCertificate == nil && len(config.Certificates) == 0 && config.GetConfigForClient == nil {
+		return nil, errors.New("TLS enabled without a certificate")
+	}
+	return config, nil
+}
+
+// getCertificate picks the first certificate valid for the client's SNI name, falling back to the
+// first one configured
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var fallback *tls.Certificate
+	for _, c := range s.certificates {
+		cert := c.current()
+		if fallback == nil {
+			fallback = cert
+		}
+
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return fallback, nil
+}
+
+// current returns the certificate, reloading it when either file changed since the last check. A
+// failed reload, such as a key and certificate caught mid-rotation, keeps the previous one in use
+func (c *certificate) current() *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert
+	}
+	c.checked = time.Now()
+
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return c.cert
+	}
+
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return c.cert
+	}
+
+	if certInfo.ModTime().Equal(c.certMod) && keyInfo.ModTime().Equal(c.keyMod) {
+		return c.cert
+	}
+
+	err = c.load(certInfo.ModTime(), keyInfo.ModTime())
+	if err != nil {
+		log.Printf("Error reloading certificate %s: %v", c.certFile, err)
+	}
+	return c.cert
+}
+
+func (c *certificate) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.checked = time.Now()
+	return c.load(certInfo.ModTime(), keyInfo.ModTime())
+}
+
+func (c *certificate) load(certMod, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.cert = &cert
+	c.certMod = certMod
+	c.keyMod = keyMod
+	return nil
+}
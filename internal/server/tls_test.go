@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for name into dir, returning the cert and key paths
+func writeCert(t *testing.T, dir, name string, serial int64) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	aCert, aKey := writeCert(t, dir, "a.test", 1)
+	bCert, bKey := writeCert(t, dir, "b.test", 2)
+
+	handler := func(w *response.Writer, req *request.Request) *HandlerError {
+		body := "plain"
+		if req.TLS != nil {
+			body = req.TLS.ServerName
+		}
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(headers.GetDefaultHeaders(len(body)))
+		_, _ = w.Write([]byte(body))
+		return nil
+	}
+
+	srv, err := Serve(0, handler, WithTLS(aCert, aKey), WithTLS(bCert, bKey))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	get := func(serverName string) (*x509.Certificate, string) {
+		conn, err := tls.Dial("tcp", srv.Addr().String(), &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		state := conn.ConnectionState()
+		assert.Equal(t, "http/1.1", state.NegotiatedProtocol)
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + serverName + "\r\n\r\n"))
+		require.NoError(t, err)
+		res, err := response.FromReader(conn)
+		require.NoError(t, err)
+		return state.PeerCertificates[0], string(res.Body)
+	}
+
+	// Test: The certificate is picked by SNI and the request carries the TLS state
+	cert, body := get("a.test")
+	assert.Equal(t, "a.test", cert.Subject.CommonName)
+	assert.Equal(t, "a.test", body)
+
+	cert, body = get("b.test")
+	assert.Equal(t, "b.test", cert.Subject.CommonName)
+	assert.Equal(t, "b.test", body)
+
+	// Test: Unknown names fall back to the first certificate
+	cert, _ = get("c.test")
+	assert.Equal(t, "a.test", cert.Subject.CommonName)
+
+	// Test: Certificates replaced on disk are served without a restart
+	writeCert(t, dir, "a.test", 3)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(aCert, later, later))
+	require.NoError(t, os.Chtimes(aKey, later, later))
+	time.Sleep(certCheckInterval + 100*time.Millisecond)
+
+	cert, _ = get("a.test")
+	assert.Equal(t, int64(3), cert.SerialNumber.Int64())
+
+	// Test: A broken replacement keeps the previous certificate
+	require.NoError(t, os.WriteFile(aKey, []byte("garbage"), 0o600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(aKey, later, later))
+	time.Sleep(certCheckInterval + 100*time.Millisecond)
+
+	cert, _ = get("a.test")
+	assert.Equal(t, int64(3), cert.SerialNumber.Int64())
+}
+
+func TestTLSWithoutCertificate(t *testing.T) {
+	_, err := Serve(0, nil, WithTLSConfig(&tls.Config{}))
+	require.Error(t, err)
+
+	_, err = Serve(0, nil, WithTLS("missing.crt", "missing.key"))
+	require.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+)
+
+const DefaultHeartbeat = 15 * time.Second
+
+var newlines = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+type Stream struct {
+	mu          sync.Mutex
+	w           *response.Writer
+	lastEventID string
+	closed      bool
+}
+
+// NewStream commits a text/event-stream response on w. The caller owns the stream until it is closed
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	err := w.WriteStatusLine(response.StatusCodeOK)
+	if err != nil {
+		return nil, err
+	}
+
+	h := headers.GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Overwrite("Content-Type", "text/event-stream")
+	h.Overwrite("Cache-Control", "no-cache")
+	h.Overwrite("Transfer-Encoding", "chunked")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("Last-Event-ID")
+	return &Stream{
+		w:           w,
+		lastEventID: lastEventID,
+	}, nil
+}
+
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	_, err := s.w.WriteChunkedBodyDone()
+	if err != nil {
+		return err
+	}
+
+	err = s.w.WriteTrailers(headers.NewHeaders())
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *Stream) Comment(text string) error {
+	var builder strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		builder.WriteString(": " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	builder.WriteString("\n")
+	return s.write(builder.String())
+}
+
+// LastEventID is the ID a reconnecting client last saw, so sources can resume after it
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Run sends events until the channel is closed or ctx is cancelled, writing a heartbeat comment
+// whenever the stream has been idle for the heartbeat interval
+func (s *Stream) Run(ctx context.Context, events <-chan Event, heartbeat time.Duration) error {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), s.Close())
+		case event, ok := <-events:
+			if !ok {
+				return s.Close()
+			}
+
+			err := s.Send(event)
+			if err != nil {
+				return err
+			}
+			ticker.Reset(heartbeat)
+		case <-ticker.C:
+			err := s.Comment("heartbeat")
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Stream) Send(event Event) error {
+	var builder strings.Builder
+
+	if event.Event != "" {
+		builder.WriteString("event: " + newlines.Replace(event.Event) + "\n")
+	}
+
+	if event.ID != "" {
+		builder.WriteString("id: " + newlines.Replace(event.ID) + "\n")
+	}
+
+	if event.Retry > 0 {
+		builder.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		builder.WriteString("data: " + line + "\n")
+	}
+	builder.WriteString("\n")
+
+	return s.write(builder.String())
+}
+
+func (s *Stream) write(str string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("stream is closed")
+	}
+
+	_, err := s.w.WriteChunkedBody([]byte(str))
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
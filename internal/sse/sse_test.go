@@ -0,0 +1,53 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	req, err := request.FromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost:42069\r\nLast-Event-ID: 7\r\n\r\n"))
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	stream, err := NewStream(response.NewWriter(&buffer), req)
+	require.NoError(t, err)
+	assert.Equal(t, "7", stream.LastEventID())
+	assert.Contains(t, buffer.String(), "content-type: text/event-stream\r\n")
+	assert.Contains(t, buffer.String(), "transfer-encoding: chunked\r\n")
+
+	// Test: All fields, with multi-line data
+	buffer.Reset()
+	err = stream.Send(Event{ID: "8", Event: "tick", Data: "line one\nline two", Retry: 3 * time.Second})
+	require.NoError(t, err)
+	event := "event: tick\nid: 8\nretry: 3000\ndata: line one\ndata: line two\n\n"
+	assert.Equal(t, fmt.Sprintf("%x\r\n%s\r\n", len(event), event), buffer.String())
+
+	// Test: Newlines cannot inject extra fields
+	buffer.Reset()
+	err = stream.Send(Event{Event: "a\ndata: injected", Data: "x"})
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), "event: a data: injected\ndata: x\n\n")
+
+	// Test: Heartbeat comments are written while idle and the stream ends with the context
+	buffer.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = stream.Run(ctx, make(chan Event), 20*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, buffer.String(), ": heartbeat\n\n")
+	assert.True(t, strings.HasSuffix(buffer.String(), "0\r\n\r\n"))
+
+	// Test: Writing after close fails
+	err = stream.Send(Event{Data: "late"})
+	require.Error(t, err)
+}
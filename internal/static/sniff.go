@@ -0,0 +1,70 @@
+package static
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+type signature struct {
+	prefix      string
+	contentType string
+}
+
+// signatures covers the binary formats commonly served without an extension
+var signatures = []signature{
+	{"%PDF-", "application/pdf"},
+	{"\x89PNG\r\n\x1a\n", "image/png"},
+	{"GIF87a", "image/gif"},
+	{"GIF89a", "image/gif"},
+	{"\xff\xd8\xff", "image/jpeg"},
+	{"PK\x03\x04", "application/zip"},
+	{"\x1f\x8b\x08", "application/gzip"},
+	{"\x00asm", "application/wasm"},
+	{"wOFF", "font/woff"},
+	{"wOF2", "font/woff2"},
+}
+
+// sniffContentType guesses the type of content from its first bytes, recognising a few binary
+// signatures, HTML and XML, and otherwise telling text from binary data
+func sniffContentType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("RIFF")) && len(data) >= 12 && string(data[8:12]) == "WEBP" {
+		return "image/webp"
+	}
+
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.contentType
+		}
+	}
+
+	text := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n\f")
+	lower := bytes.ToLower(text[:min(len(text), 14)])
+	switch {
+	case bytes.HasPrefix(lower, []byte("<!doctype html")), bytes.HasPrefix(lower, []byte("<html")):
+		return "text/html; charset=utf-8"
+	case bytes.HasPrefix(lower, []byte("<?xml")):
+		return "text/xml; charset=utf-8"
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether data is UTF-8 without control characters other than whitespace and escape.
+// A rune cut off at the end of the sniffed bytes does not count against it
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return !utf8.FullRune(data)
+		}
+
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != 0x1b {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
@@ -0,0 +1,273 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+)
+
+const sniffLen = 512
+
+// Dir is an fs.FS rooted at a directory on disk. Files are opened through os.Root, so neither ".."
+// nor symlinks can resolve to anything outside the directory
+type Dir string
+
+type Option func(*fileServer)
+
+type fileServer struct {
+	fsys        fs.FS
+	index       string
+	listDirs    bool
+	stripPrefix string
+}
+
+func WithDirectoryListing() Option {
+	return func(s *fileServer) {
+		s.listDirs = true
+	}
+}
+
+func WithIndex(name string) Option {
+	return func(s *fileServer) {
+		s.index = name
+	}
+}
+
+func WithStripPrefix(prefix string) Option {
+	return func(s *fileServer) {
+		s.stripPrefix = prefix
+	}
+}
+
+func (d Dir) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	root, err := os.OpenRoot(string(d))
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	return root.Open(name)
+}
+
+func (s *fileServer) handle(w *response.Writer, req *request.Request) *server.HandlerError {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		h := headers.NewHeaders()
+		h.Set("Allow", "GET, HEAD")
+		return &server.HandlerError{StatusCode: response.StatusCodeMethodNotAllowed, Headers: h}
+	}
+
+	requestPath, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	target, found := strings.CutPrefix(requestPath, s.stripPrefix)
+	if !found {
+		return &server.HandlerError{StatusCode: response.StatusCodeNotFound}
+	}
+
+	name, err := cleanPath(target)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusCodeBadRequest}
+	}
+
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return errorFor(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return errorFor(err)
+	}
+
+	if !info.IsDir() {
+		return s.serveFile(w, req, file, info)
+	}
+
+	if !strings.HasSuffix(target, "/") {
+		err := w.Redirect(requestPath+"/", response.StatusCodeMovedPermanently)
+		if err != nil {
+			log.Println(err)
+		}
+		return nil
+	}
+
+	index, err := s.fsys.Open(path.Join(name, s.index))
+	if err == nil {
+		defer index.Close()
+
+		indexInfo, err := index.Stat()
+		if err == nil && !indexInfo.IsDir() {
+			return s.serveFile(w, req, index, indexInfo)
+		}
+	}
+
+	if !s.listDirs {
+		return &server.HandlerError{StatusCode: response.StatusCodeForbidden}
+	}
+
+	return s.serveListing(w, file, target)
+}
+
+func (s *fileServer) serveFile(w *response.Writer, req *request.Request, file fs.File, info fs.FileInfo) *server.HandlerError {
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		log.Printf("static: %s does not support seeking", info.Name())
+		return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError}
+	}
+
+	contentType, err := detectContentType(info.Name(), content)
+	if err != nil {
+		log.Println(err)
+		return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError}
+	}
+
+	err = w.ServeContent(req, content, info.Size(), info.ModTime(), contentType)
+	if err != nil {
+		log.Println(err)
+		return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError}
+	}
+	return nil
+}
+
+func (s *fileServer) serveListing(w *response.Writer, dir fs.File, target string) *server.HandlerError {
+	reader, ok := dir.(fs.ReadDirFile)
+	if !ok {
+		return &server.HandlerError{StatusCode: response.StatusCodeForbidden}
+	}
+
+	entries, err := reader.ReadDir(-1)
+	if err != nil {
+		log.Println(err)
+		return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	var body strings.Builder
+	title := html.EscapeString(target)
+	fmt.Fprintf(&body, "<html>\n  <head>\n    <title>Index of %s</title>\n  </head>\n  <body>\n    <h1>Index of %s</h1>\n    <ul>\n", title, title)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(&body, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	body.WriteString("    </ul>\n  </body>\n</html>")
+
+	err = w.WriteStatusLine(response.StatusCodeOK)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError}
+	}
+
+	h := headers.GetDefaultHeaders(body.Len())
+	h.Overwrite("Content-Type", "text/html; charset=utf-8")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	_, err = w.WriteBody([]byte(body.String()))
+	if err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+func FileServer(fsys fs.FS, opts ...Option) server.Handler {
+	s := &fileServer{
+		fsys:  fsys,
+		index: "index.html",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s.handle
+}
+
+// cleanPath turns a request target into an fs.FS name, rejecting anything that could step outside
+// the root before or after percent-decoding
+func cleanPath(target string) (string, error) {
+	lower := strings.ToLower(target)
+	if strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c") {
+		return "", errors.New("encoded path separator")
+	}
+
+	decoded, err := url.PathUnescape(target)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.ContainsAny(decoded, "\\\x00") {
+		return "", errors.New("invalid character in path")
+	}
+
+	for _, segment := range strings.Split(decoded, "/") {
+		if segment == ".." {
+			return "", errors.New("path traversal")
+		}
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+decoded), "/")
+	if name == "" {
+		name = "."
+	}
+
+	if !fs.ValidPath(name) {
+		return "", errors.New("invalid path")
+	}
+	return name, nil
+}
+
+func detectContentType(name string, content io.ReadSeeker) (string, error) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType != "" {
+		return contentType, nil
+	}
+
+	buffer := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	return sniffContentType(buffer[:n]), nil
+}
+
+func errorFor(err error) *server.HandlerError {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		return &server.HandlerError{StatusCode: response.StatusCodeNotFound}
+	}
+
+	if errors.Is(err, fs.ErrPermission) {
+		return &server.HandlerError{StatusCode: response.StatusCodeForbidden}
+	}
+
+	// os.Root reports symlinks that resolve outside the root as a plain error
+	log.Println(err)
+	return &server.HandlerError{StatusCode: response.StatusCodeNotFound}
+}
@@ -0,0 +1,169 @@
+package static
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanPath(t *testing.T) {
+	// Test: Root
+	name, err := cleanPath("/")
+	require.NoError(t, err)
+	assert.Equal(t, ".", name)
+
+	// Test: Nested file
+	name, err = cleanPath("/css/site.css")
+	require.NoError(t, err)
+	assert.Equal(t, "css/site.css", name)
+
+	// Test: Percent encoded characters
+	name, err = cleanPath("/my%20file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "my file.txt", name)
+
+	// Test: Redundant separators are cleaned
+	name, err = cleanPath("//css/./site.css")
+	require.NoError(t, err)
+	assert.Equal(t, "css/site.css", name)
+
+	// Test: Parent directory segments
+	_, err = cleanPath("/../etc/passwd")
+	require.Error(t, err)
+
+	_, err = cleanPath("/css/../../etc/passwd")
+	require.Error(t, err)
+
+	// Test: Percent encoded parent directory segments
+	_, err = cleanPath("/%2e%2e/etc/passwd")
+	require.Error(t, err)
+
+	// Test: Encoded slashes
+	_, err = cleanPath("/css%2f..%2f..%2fetc/passwd")
+	require.Error(t, err)
+
+	_, err = cleanPath("/css%5C..%5Csecret")
+	require.Error(t, err)
+
+	// Test: Backslashes and NUL bytes
+	_, err = cleanPath("/css\\..\\secret")
+	require.Error(t, err)
+
+	_, err = cleanPath("/secret%00.txt")
+	require.Error(t, err)
+}
+
+func TestSniffContentType(t *testing.T) {
+	for data, expected := range map[string]string{
+		"":                             "text/plain; charset=utf-8",
+		"plain text\n":                 "text/plain; charset=utf-8",
+		"caf\xc3\xa9":                  "text/plain; charset=utf-8",
+		"caf\xc3":                      "text/plain; charset=utf-8",
+		"  <!DOCTYPE html><p>hi</p>":   "text/html; charset=utf-8",
+		"\xef\xbb\xbf<html>":           "text/html; charset=utf-8",
+		"<?xml version=\"1.0\"?>":      "text/xml; charset=utf-8",
+		"%PDF-1.7":                     "application/pdf",
+		"\x89PNG\r\n\x1a\n\x00\x00":    "image/png",
+		"RIFF\x00\x00\x00\x00WEBPVP8 ": "image/webp",
+		"\x1f\x8b\x08\x00":             "application/gzip",
+		"\x00\x01\x02\x03":             "application/octet-stream",
+		"\xff\xfe\xfd":                 "application/octet-stream",
+	} {
+		// Test: Known signatures, markup and text are recognised, anything else is binary
+		assert.Equal(t, expected, sniffContentType([]byte(data)), "%q", data)
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs", "sub"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a <b>.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<p>home</p>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink(base, filepath.Join(root, "escape")))
+
+	serve := func(handler server.Handler, target string) (*response.Response, *server.HandlerError) {
+		var buffer bytes.Buffer
+		w := response.NewWriter(&buffer)
+		hErr := handler(w, request.NewRequest("GET", target, nil))
+		if hErr != nil {
+			return nil, hErr
+		}
+		require.NoError(t, w.Finish())
+		require.NoError(t, w.Flush())
+
+		res, err := response.FromReader(&buffer)
+		require.NoError(t, err)
+		return res, nil
+	}
+	handler := FileServer(Dir(root), WithStripPrefix("/assets"), WithDirectoryListing())
+
+	// Test: Files are served with a type from their extension
+	res, hErr := serve(handler, "/assets/hello.txt")
+	require.Nil(t, hErr)
+	assert.Equal(t, "hello", string(res.Body))
+	assert.Equal(t, "text/plain; charset=utf-8", res.Headers["content-type"])
+	assert.NotEmpty(t, res.Headers["etag"])
+
+	// Test: Directories serve their index.html
+	res, hErr = serve(handler, "/assets/site/")
+	require.Nil(t, hErr)
+	assert.Equal(t, "<p>home</p>", string(res.Body))
+
+	// Test: Directories without a trailing slash are redirected
+	res, hErr = serve(handler, "/assets/site")
+	require.Nil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeMovedPermanently), res.StatusLine.StatusCode)
+	assert.Equal(t, "/assets/site/", res.Headers["location"])
+
+	// Test: Directories without an index are listed with escaped names
+	res, hErr = serve(handler, "/assets/docs/")
+	require.Nil(t, hErr)
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers["content-type"])
+	assert.Contains(t, string(res.Body), `<a href="a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`)
+	assert.Contains(t, string(res.Body), `<a href="sub/">sub/</a>`)
+
+	// Test: Listings are forbidden unless enabled
+	_, hErr = serve(FileServer(Dir(root), WithStripPrefix("/assets")), "/assets/docs/")
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeForbidden), hErr.StatusCode)
+
+	// Test: Symlinks cannot reach outside the root
+	for _, target := range []string{"/assets/escape.txt", "/assets/escape/secret.txt"} {
+		_, hErr = serve(handler, target)
+		require.NotNil(t, hErr, target)
+		assert.NotEqual(t, response.StatusCode(response.StatusCodeOK), hErr.StatusCode)
+	}
+
+	// Test: Traversal, missing files and other prefixes
+	_, hErr = serve(handler, "/assets/../secret.txt")
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeBadRequest), hErr.StatusCode)
+
+	_, hErr = serve(handler, "/assets/missing.txt")
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeNotFound), hErr.StatusCode)
+
+	_, hErr = serve(handler, "/other/hello.txt")
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeNotFound), hErr.StatusCode)
+
+	// Test: Other methods are refused with the ones allowed
+	hErr = handler(response.NewWriter(io.Discard), request.NewRequest("POST", "/assets/hello.txt", nil))
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusCode(response.StatusCodeMethodNotAllowed), hErr.StatusCode)
+	assert.Equal(t, "GET, HEAD", hErr.Headers["allow"])
+}
@@ -0,0 +1,460 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const (
+	closeTimeout      = 5 * time.Second
+	maxControlPayload = 125
+	finBit            = 0x80
+	rsv1Bit           = 0x40
+	rsvBits           = 0x70
+	maskBit           = 0x80
+	deflateTail       = "\x00\x00\xff\xff"
+	deflateFinalBlock = "\x01\x00\x00\xff\xff"
+)
+
+var ErrClosed = errors.New("websocket closed")
+
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+type Frame struct {
+	Fin     bool
+	Rsv1    bool
+	Opcode  Opcode
+	Payload []byte
+}
+
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	client         bool
+	compress       bool
+	maxMessageSize int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	return &Conn{
+		conn:           conn,
+		reader:         reader,
+		client:         client,
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Close runs the closing handshake with a normal closure code and then closes the connection
+func (c *Conn) Close() error {
+	return c.CloseWithReason(CloseNormalClosure, "")
+}
+
+// CloseWithReason sends a close frame, waits briefly for the peer to answer with its own, and closes
+// the underlying connection
+func (c *Conn) CloseWithReason(code int, reason string) error {
+	err := c.writeClose(code, reason)
+	if errors.Is(err, ErrClosed) {
+		// The handshake already happened in ReadMessage, only the connection is left
+		err = c.conn.Close()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		return err
+	}
+
+	if err == nil {
+		_ = c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			frame, err := c.ReadFrame()
+			if err != nil || frame.Opcode == OpClose {
+				break
+			}
+		}
+	}
+
+	closeErr := c.conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+func (c *Conn) Ping(data []byte) error {
+	return c.WriteFrame(Frame{Fin: true, Opcode: OpPing, Payload: data})
+}
+
+// ReadFrame reads a single frame, unmasking client payloads. Protocol violations are reported to the
+// peer with a close frame before the error is returned
+func (c *Conn) ReadFrame() (Frame, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.reader, header)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	frame := Frame{
+		Fin:    header[0]&finBit != 0,
+		Rsv1:   header[0]&rsv1Bit != 0,
+		Opcode: Opcode(header[0] & 0x0f),
+	}
+
+	if header[0]&rsvBits&^rsv1Bit != 0 || (frame.Rsv1 && !c.compress) {
+		return Frame{}, c.fail(CloseProtocolError, "reserved bits set")
+	}
+
+	masked := header[1]&maskBit != 0
+	if masked == c.client {
+		return Frame{}, c.fail(CloseProtocolError, "invalid frame masking")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, extended)
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(c.reader, extended)
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if err != nil {
+		return Frame{}, err
+	}
+
+	if isControl(frame.Opcode) && (length > maxControlPayload || !frame.Fin) {
+		return Frame{}, c.fail(CloseProtocolError, "invalid control frame")
+	}
+
+	if length > uint64(c.maxMessageSize) {
+		return Frame{}, c.fail(CloseMessageTooBig, "frame too large")
+	}
+
+	var key [4]byte
+	if masked {
+		_, err = io.ReadFull(c.reader, key[:])
+		if err != nil {
+			return Frame{}, err
+		}
+	}
+
+	frame.Payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, frame.Payload)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	if masked {
+		mask(frame.Payload, key)
+	}
+	return frame, nil
+}
+
+// ReadMessage returns the next complete data message. Fragments are reassembled, pings are answered,
+// and a close frame from the peer is acknowledged and returned as a *CloseError
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var opcode Opcode
+	var compressed bool
+	var message []byte
+
+	for {
+		frame, err := c.ReadFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.Opcode {
+		case OpPing:
+			err := c.WriteFrame(Frame{Fin: true, Opcode: OpPong, Payload: frame.Payload})
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return 0, nil, c.handleClose(frame.Payload)
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			opcode = frame.Opcode
+			compressed = frame.Rsv1
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			if frame.Rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(message)+len(frame.Payload)) > c.maxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too large")
+		}
+		message = append(message, frame.Payload...)
+
+		if !frame.Fin {
+			continue
+		}
+
+		if compressed {
+			message, err = c.inflate(message)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+
+		if opcode == OpText && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8 in text message")
+		}
+		return opcode, message, nil
+	}
+}
+
+func (c *Conn) WriteFrame(frame Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	if frame.Opcode == OpClose {
+		c.closeSent = true
+	}
+	return c.writeFrame(frame)
+}
+
+// WriteFragmented writes data as a single message split into frames of at most fragmentSize bytes
+func (c *Conn) WriteFragmented(opcode Opcode, data []byte, fragmentSize int) error {
+	if fragmentSize <= 0 {
+		return errors.New("fragment size must be positive")
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	payload, compressed, err := c.deflate(data)
+	if err != nil {
+		return err
+	}
+
+	first := true
+	for {
+		n := min(fragmentSize, len(payload))
+		frame := Frame{
+			Fin:     n == len(payload),
+			Rsv1:    first && compressed,
+			Opcode:  opcode,
+			Payload: payload[:n],
+		}
+		if !first {
+			frame.Opcode = OpContinuation
+		}
+
+		err := c.writeFrame(frame)
+		if err != nil || frame.Fin {
+			return err
+		}
+
+		payload = payload[n:]
+		first = false
+	}
+}
+
+func (c *Conn) WriteMessage(opcode Opcode, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	payload, compressed, err := c.deflate(data)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(Frame{Fin: true, Rsv1: compressed, Opcode: opcode, Payload: payload})
+}
+
+func (c *Conn) deflate(data []byte) ([]byte, bool, error) {
+	if !c.compress {
+		return data, false, nil
+	}
+
+	var buffer bytes.Buffer
+	writer, err := flate.NewWriter(&buffer, flate.DefaultCompression)
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return nil, false, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte(deflateTail)), true, nil
+}
+
+func (c *Conn) fail(code int, reason string) error {
+	_ = c.writeClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+	}
+
+	if len(payload) == 1 || (len(payload) >= 2 && !utf8.Valid(payload[2:])) {
+		_ = c.writeClose(CloseProtocolError, "invalid close frame")
+		return closeErr
+	}
+
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+	_ = c.writeClose(code, "")
+	return closeErr
+}
+
+func (c *Conn) inflate(data []byte) ([]byte, error) {
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader([]byte(deflateTail+deflateFinalBlock))))
+	defer reader.Close()
+
+	inflated, err := io.ReadAll(io.LimitReader(reader, c.maxMessageSize+1))
+	if err != nil {
+		return nil, c.fail(CloseInvalidPayload, "invalid compressed payload")
+	}
+
+	if int64(len(inflated)) > c.maxMessageSize {
+		return nil, c.fail(CloseMessageTooBig, "message too large")
+	}
+	return inflated, nil
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return c.WriteFrame(Frame{Fin: true, Opcode: OpClose, Payload: payload})
+}
+
+func (c *Conn) writeFrame(frame Frame) error {
+	header := make([]byte, 0, 14)
+
+	first := byte(frame.Opcode)
+	if frame.Fin {
+		first |= finBit
+	}
+	if frame.Rsv1 {
+		first |= rsv1Bit
+	}
+	header = append(header, first)
+
+	var maskFlag byte
+	if c.client {
+		maskFlag = maskBit
+	}
+
+	length := len(frame.Payload)
+	switch {
+	case length <= 125:
+		header = append(header, maskFlag|byte(length))
+	case length <= 0xffff:
+		header = append(header, maskFlag|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, maskFlag|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	payload := frame.Payload
+	if c.client {
+		var key [4]byte
+		_, err := rand.Read(key[:])
+		if err != nil {
+			return err
+		}
+		header = append(header, key[:]...)
+
+		payload = bytes.Clone(payload)
+		mask(payload, key)
+	}
+
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+func isControl(opcode Opcode) bool {
+	return opcode&0x8 != 0
+}
+
+func mask(data []byte, key [4]byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
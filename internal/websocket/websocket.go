@@ -0,0 +1,211 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/headers"
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/MadhurSahu/tcp-to-http/internal/server"
+)
+
+const (
+	acceptGUID            = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	DefaultMaxMessageSize = 1 << 20
+)
+
+var (
+	ErrBadHandshake       = errors.New("bad websocket handshake")
+	ErrUnsupportedVersion = errors.New("unsupported websocket version")
+)
+
+type Option func(*upgrader)
+
+type upgrader struct {
+	compression    bool
+	maxMessageSize int64
+	subprotocols   []string
+}
+
+func WithCompression() Option {
+	return func(u *upgrader) {
+		u.compression = true
+	}
+}
+
+func WithMaxMessageSize(size int64) Option {
+	return func(u *upgrader) {
+		u.maxMessageSize = size
+	}
+}
+
+func WithSubprotocols(subprotocols ...string) Option {
+	return func(u *upgrader) {
+		u.subprotocols = subprotocols
+	}
+}
+
+// Handler upgrades every request to a websocket and hands the connection to fn, closing it once fn
+// returns. Failed handshakes are answered with 400, or 426 for unsupported versions
+func Handler(fn func(conn *Conn, req *request.Request), opts ...Option) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		conn, err := Upgrade(w, req, opts...)
+		if errors.Is(err, ErrUnsupportedVersion) {
+			h := headers.NewHeaders()
+			h.Overwrite("Sec-WebSocket-Version", "13")
+			return &server.HandlerError{StatusCode: response.StatusCodeUpgradeRequired, Headers: h, Err: err}
+		}
+
+		if errors.Is(err, ErrBadHandshake) {
+			return &server.HandlerError{StatusCode: response.StatusCodeBadRequest, Message: err.Error()}
+		}
+
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusCodeInternalServerError, Err: err}
+		}
+
+		defer func() {
+			err := conn.Close()
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+
+		fn(conn, req)
+		return nil
+	}
+}
+
+// Upgrade validates the opening handshake in req, answers it with 101 Switching Protocols and takes
+// over the connection from w
+func Upgrade(w *response.Writer, req *request.Request, opts ...Option) (*Conn, error) {
+	u := &upgrader{
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	if req.RequestLine.Method != "GET" {
+		return nil, fmt.Errorf("%w: method must be GET", ErrBadHandshake)
+	}
+
+	if !headerContainsToken(req.Headers, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("%w: missing Upgrade: websocket", ErrBadHandshake)
+	}
+
+	if !headerContainsToken(req.Headers, "Connection", "upgrade") {
+		return nil, fmt.Errorf("%w: missing Connection: Upgrade", ErrBadHandshake)
+	}
+
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); strings.TrimSpace(version) != "13" {
+		return nil, ErrUnsupportedVersion
+	}
+
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+
+	h := headers.NewHeaders()
+	h.Overwrite("Upgrade", "websocket")
+	h.Overwrite("Connection", "Upgrade")
+	h.Overwrite("Sec-WebSocket-Accept", AcceptKey(key))
+
+	if subprotocol := u.selectSubprotocol(req.Headers); subprotocol != "" {
+		h.Overwrite("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	compress := u.compression && offersDeflate(req.Headers)
+	if compress {
+		// Without context takeover each message is its own deflate stream, which keeps both sides stateless
+		h.Overwrite("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	err = w.WriteStatusLine(response.StatusCodeSwitchingProtocols)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// A client may send its first frames right behind the handshake
+	conn := newConn(netConn, bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), netConn)), false)
+	conn.compress = compress
+	conn.maxMessageSize = u.maxMessageSize
+	return conn, nil
+}
+
+func (u *upgrader) selectSubprotocol(h headers.Headers) string {
+	offered, exists := h.Get("Sec-WebSocket-Protocol")
+	if !exists {
+		return ""
+	}
+
+	for _, subprotocol := range strings.Split(offered, ",") {
+		subprotocol = strings.TrimSpace(subprotocol)
+		if slices.Contains(u.subprotocols, subprotocol) {
+			return subprotocol
+		}
+	}
+	return ""
+}
+
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContainsToken(h headers.Headers, key, token string) bool {
+	val, _ := h.Get(key)
+	for _, part := range strings.Split(val, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// offersDeflate reports whether any permessage-deflate offer can be accepted. compress/flate always
+// uses the full 32KB window, so offers limiting the server window are declined
+func offersDeflate(h headers.Headers) bool {
+	val, _ := h.Get("Sec-WebSocket-Extensions")
+	for _, extension := range strings.Split(val, ",") {
+		params := strings.Split(extension, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+			continue
+		}
+
+		acceptable := true
+		for _, param := range params[1:] {
+			key, val, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "server_max_window_bits") && strings.Trim(strings.TrimSpace(val), "\"") != "15" {
+				acceptable = false
+			}
+		}
+
+		if acceptable {
+			return true
+		}
+	}
+	return false
+}
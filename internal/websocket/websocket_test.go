@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/MadhurSahu/tcp-to-http/internal/request"
+	"github.com/MadhurSahu/tcp-to-http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Test: Example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgrade(t *testing.T) {
+	handshake := "GET /ws HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+
+	// Test: Unsupported version
+	req, err := request.FromReader(strings.NewReader(handshake + "Sec-WebSocket-Version: 8\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = Upgrade(response.NewWriter(&bytes.Buffer{}), req)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// Test: Missing key
+	req, err = request.FromReader(strings.NewReader("GET /ws HTTP/1.1\r\nHost: localhost:42069\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = Upgrade(response.NewWriter(&bytes.Buffer{}), req)
+	require.ErrorIs(t, err, ErrBadHandshake)
+
+	// Test: Valid handshake is answered before the connection is hijacked
+	req, err = request.FromReader(strings.NewReader(handshake + "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: chat, superchat\r\nSec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n\r\n"))
+	require.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan *Conn)
+	go func() {
+		conn, err := Upgrade(response.NewWriter(server), req, WithSubprotocols("superchat"), WithCompression())
+		assert.NoError(t, err)
+		done <- conn
+	}()
+
+	reader := bufio.NewReader(client)
+	var head strings.Builder
+	for !strings.HasSuffix(head.String(), "\r\n\r\n") {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		head.WriteString(line)
+	}
+
+	conn := <-done
+	require.NotNil(t, conn)
+	assert.True(t, strings.HasPrefix(head.String(), "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, head.String(), "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head.String(), "sec-websocket-protocol: superchat\r\n")
+	assert.Contains(t, head.String(), "sec-websocket-extensions: permessage-deflate")
+	assert.True(t, conn.compress)
+}
+
+func TestConn(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	server := newConn(serverConn, bufio.NewReader(serverConn), false)
+	client := newConn(clientConn, bufio.NewReader(clientConn), true)
+
+	type message struct {
+		opcode Opcode
+		data   []byte
+		err    error
+	}
+
+	read := func(c *Conn) <-chan message {
+		ch := make(chan message, 1)
+		go func() {
+			opcode, data, err := c.ReadMessage()
+			ch <- message{opcode, data, err}
+		}()
+		return ch
+	}
+
+	// Test: Masked client text message
+	received := read(server)
+	require.NoError(t, client.WriteMessage(OpText, []byte("hello")))
+	m := <-received
+	require.NoError(t, m.err)
+	assert.Equal(t, OpText, m.opcode)
+	assert.Equal(t, "hello", string(m.data))
+
+	// Test: Fragments are reassembled and a ping in between is answered
+	received = read(server)
+	pong := make(chan Frame, 1)
+	go func() {
+		require.NoError(t, client.WriteFrame(Frame{Opcode: OpBinary, Payload: []byte("abc")}))
+		require.NoError(t, client.Ping([]byte("are you there")))
+		frame, err := client.ReadFrame()
+		assert.NoError(t, err)
+		pong <- frame
+		require.NoError(t, client.WriteFrame(Frame{Fin: true, Opcode: OpContinuation, Payload: []byte("def")}))
+	}()
+	frame := <-pong
+	assert.Equal(t, OpPong, frame.Opcode)
+	assert.Equal(t, "are you there", string(frame.Payload))
+	m = <-received
+	require.NoError(t, m.err)
+	assert.Equal(t, OpBinary, m.opcode)
+	assert.Equal(t, "abcdef", string(m.data))
+
+	// Test: Extended payload length written by the server
+	received = read(client)
+	large := bytes.Repeat([]byte("x"), 70000)
+	require.NoError(t, server.WriteFragmented(OpBinary, large, 30000))
+	m = <-received
+	require.NoError(t, m.err)
+	assert.Equal(t, large, m.data)
+
+	// Test: permessage-deflate round trip
+	server.compress = true
+	client.compress = true
+	received = read(server)
+	text := strings.Repeat("compress me ", 500)
+	require.NoError(t, client.WriteMessage(OpText, []byte(text)))
+	m = <-received
+	require.NoError(t, m.err)
+	assert.Equal(t, text, string(m.data))
+
+	// Test: Close is echoed with the same code
+	received = read(server)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.CloseWithReason(CloseGoingAway, "bye")
+	}()
+	m = <-received
+	var closeErr *CloseError
+	require.ErrorAs(t, m.err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+	require.NoError(t, <-closed)
+	require.NoError(t, server.Close())
+}
+
+func TestReadFrameProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked client frame", []byte{0x81, 0x02, 'h', 'i'}, CloseProtocolError},
+		{"reserved bits", []byte{0xc1, 0x80, 0, 0, 0, 0}, CloseProtocolError},
+		{"fragmented control frame", []byte{0x09, 0x80, 0, 0, 0, 0}, CloseProtocolError},
+		{"invalid UTF-8", []byte{0x81, 0x82, 0, 0, 0, 0, 0xc3, 0x28}, CloseInvalidPayload},
+		{"too large", []byte{0x82, 0xff, 0, 0, 0, 0, 0, 0x20, 0, 0}, CloseMessageTooBig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			defer clientConn.Close()
+			server := newConn(serverConn, bufio.NewReader(bytes.NewReader(tt.frame)), false)
+
+			go func() {
+				_, _, err := server.ReadMessage()
+				var closeErr *CloseError
+				assert.ErrorAs(t, err, &closeErr)
+				serverConn.Close()
+			}()
+
+			client := newConn(clientConn, bufio.NewReader(clientConn), true)
+			frame, err := client.ReadFrame()
+			require.NoError(t, err)
+			assert.Equal(t, OpClose, frame.Opcode)
+			assert.Equal(t, tt.code, int(frame.Payload[0])<<8|int(frame.Payload[1]))
+		})
+	}
+}